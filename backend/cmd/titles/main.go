@@ -2,14 +2,109 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 
+	"github.com/jonwilberg/stream-finder/internal/api"
+	"github.com/jonwilberg/stream-finder/internal/config"
+	"github.com/jonwilberg/stream-finder/internal/metrics"
+	"github.com/jonwilberg/stream-finder/internal/telemetry"
 	"github.com/jonwilberg/stream-finder/internal/titles"
+	"github.com/jonwilberg/stream-finder/pkg/logging"
 )
 
+// Usage: titles [update|serve|config|reports] [flags]
+//
+// update syncs the titles and is the default. serve runs the API until it is
+// interrupted. config prints the configuration with secrets redacted. reports
+// prints the reports of the most recent sync runs as JSON.
 func main() {
-	ctx := context.Background()
-	if err := titles.UpdateTitles(ctx); err != nil {
-		log.Fatalf("Error updating titles: %v", err)
+	command, args := config.CommandUpdate, os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = config.Command(args[0]), args[1:]
 	}
+
+	cfg, err := config.Load(command, args, os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("Error loading config: %v", err)
+	}
+	if err := cfg.Validate(command); err != nil {
+		log.Fatalf("Invalid config: %v", err)
+	}
+	logging.SetProgressConfig(cfg.Log)
+
+	if err := run(command, cfg); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(command config.Command, cfg config.Config) (err error) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	shutdown, err := telemetry.Setup(ctx, cfg.Telemetry)
+	if err != nil {
+		return fmt.Errorf("Error setting up telemetry: %w", err)
+	}
+	defer func() {
+		// Flush the telemetry even if the run was interrupted.
+		if shutdownErr := shutdown(context.WithoutCancel(ctx)); shutdownErr != nil {
+			err = errors.Join(err, fmt.Errorf("Error shutting down telemetry: %w", shutdownErr))
+		}
+	}()
+
+	switch command {
+	case config.CommandUpdate:
+		_, err := titles.UpdateTitles(ctx, cfg)
+		// Failed runs push their metrics too, so that failures are visible.
+		if pushErr := metrics.Push(context.WithoutCancel(ctx), cfg.Metrics, err == nil); pushErr != nil {
+			slog.Warn("Failed to push metrics", "error", pushErr)
+		}
+		if err != nil {
+			return fmt.Errorf("Error updating titles: %w", err)
+		}
+	case config.CommandServe:
+		if err := api.Run(ctx, cfg); err != nil {
+			return fmt.Errorf("Error serving API: %w", err)
+		}
+	case config.CommandConfig:
+		fmt.Print(cfg)
+	case config.CommandReports:
+		if err := printReports(ctx, cfg); err != nil {
+			return fmt.Errorf("Error printing sync reports: %w", err)
+		}
+	}
+	return nil
+}
+
+const recentReports = 10
+
+func printReports(ctx context.Context, cfg config.Config) error {
+	reports, err := titles.OpenReportStore(ctx, cfg.Sync.ReportStore(), cfg.Firestore)
+	if err != nil {
+		return err
+	}
+	if reports == nil {
+		return errors.New("sync reports are not kept, see -reports")
+	}
+	defer reports.Close()
+
+	recent, err := reports.List(ctx, recentReports)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(recent)
 }
@@ -0,0 +1,251 @@
+// Package api serves titles over HTTP.
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jonwilberg/stream-finder/internal/availability"
+	"github.com/jonwilberg/stream-finder/internal/config"
+	"github.com/jonwilberg/stream-finder/internal/metrics"
+	"github.com/jonwilberg/stream-finder/internal/repos/elasticsearch"
+	"github.com/jonwilberg/stream-finder/internal/syncreport"
+	"github.com/jonwilberg/stream-finder/internal/titles"
+)
+
+var tconstPattern = regexp.MustCompile(`^tt\d+$`)
+
+const (
+	defaultReportLimit = 20
+	maxReportLimit     = 100
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+type Server struct {
+	elasticsearchRepo *elasticsearch.Repository
+	netflixStore      availability.Store
+	reports           syncreport.Store
+	mux               *http.ServeMux
+}
+
+// NewServer creates the API server. reports may be nil if sync reports are not
+// kept, in which case the sync run routes answer 404.
+func NewServer(elasticsearchRepo *elasticsearch.Repository, netflixStore availability.Store, reports syncreport.Store) *Server {
+	s := &Server{
+		elasticsearchRepo: elasticsearchRepo,
+		netflixStore:      netflixStore,
+		reports:           reports,
+		mux:               http.NewServeMux(),
+	}
+	s.mux.Handle("GET /v1/titles", metrics.InstrumentHandler("/v1/titles", s.handleSearchTitles))
+	s.mux.Handle("GET /v1/titles/{tconst}", metrics.InstrumentHandler("/v1/titles/{tconst}", s.handleGetTitle))
+	s.mux.Handle("GET /v1/sync-runs", metrics.InstrumentHandler("/v1/sync-runs", s.handleListSyncRuns))
+	s.mux.Handle("GET /v1/sync-runs/{id}", metrics.InstrumentHandler("/v1/sync-runs/{id}", s.handleGetSyncRun))
+	s.mux.Handle("GET /metrics", metrics.Handler())
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// handleGetTitle returns the IMDb title with its availability, see
+// titles.TitleDetail.
+func (s *Server) handleGetTitle(w http.ResponseWriter, r *http.Request) {
+	tconst := r.PathValue("tconst")
+	if !tconstPattern.MatchString(tconst) {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("%q is not an IMDb title ID", tconst))
+		return
+	}
+
+	detail, err := titles.GetTitleDetail(r.Context(), s.elasticsearchRepo, s.netflixStore, tconst)
+	if errors.Is(err, elasticsearch.ErrNotFound) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("title %s not found", tconst))
+		return
+	}
+	if err != nil {
+		slog.Error("Failed to get title", "tconst", tconst, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to get title")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, detail)
+}
+
+// searchResult is a title found by a search.
+type searchResult struct {
+	ID            string   `json:"id"`
+	TitleType     string   `json:"title_type"`
+	Title         string   `json:"title"`
+	OriginalTitle string   `json:"original_title"`
+	IsAdult       bool     `json:"is_adult"`
+	Year          int      `json:"year,omitempty"`
+	Genres        []string `json:"genres"`
+}
+
+// handleSearchTitles returns the titles matching the q query parameter, best
+// match first. The genre query parameter keeps the titles listed under a
+// Netflix genre ID and limit caps how many are returned.
+func (s *Server) handleSearchTitles(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		writeError(w, http.StatusBadRequest, "q is required")
+		return
+	}
+	limit, ok := parseLimit(w, r, defaultSearchLimit, maxSearchLimit)
+	if !ok {
+		return
+	}
+
+	documents, err := titles.SearchTitles(r.Context(), s.elasticsearchRepo, query, r.URL.Query().Get("genre"), limit)
+	if err != nil {
+		slog.Error("Failed to search titles", "query", query, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to search titles")
+		return
+	}
+
+	results := make([]searchResult, 0, len(documents))
+	for _, document := range documents {
+		results = append(results, searchResult{
+			ID:            document.ID,
+			TitleType:     document.Body.TitleType,
+			Title:         document.Body.Title,
+			OriginalTitle: document.Body.OriginalTitle,
+			IsAdult:       document.Body.IsAdult,
+			Year:          document.Body.Year,
+			Genres:        document.Body.Genres,
+		})
+	}
+	writeJSON(w, http.StatusOK, results)
+}
+
+// handleListSyncRuns returns the reports of the most recent sync runs, most
+// recent first. The limit query parameter caps how many are returned.
+func (s *Server) handleListSyncRuns(w http.ResponseWriter, r *http.Request) {
+	if s.reports == nil {
+		writeError(w, http.StatusNotFound, "sync reports are not kept")
+		return
+	}
+
+	limit, ok := parseLimit(w, r, defaultReportLimit, maxReportLimit)
+	if !ok {
+		return
+	}
+
+	reports, err := s.reports.List(r.Context(), limit)
+	if err != nil {
+		slog.Error("Failed to list sync reports", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to list sync runs")
+		return
+	}
+	if reports == nil {
+		reports = []syncreport.Report{}
+	}
+
+	writeJSON(w, http.StatusOK, reports)
+}
+
+func (s *Server) handleGetSyncRun(w http.ResponseWriter, r *http.Request) {
+	if s.reports == nil {
+		writeError(w, http.StatusNotFound, "sync reports are not kept")
+		return
+	}
+
+	id := r.PathValue("id")
+	report, err := s.reports.Get(r.Context(), id)
+	if errors.Is(err, syncreport.ErrNotFound) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("sync run %s not found", id))
+		return
+	}
+	if err != nil {
+		slog.Error("Failed to get sync report", "id", id, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to get sync run")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, report)
+}
+
+// parseLimit reads the limit query parameter, which defaults to
+// defaultLimit. If it is not between 1 and maxLimit, a 400 is written and ok
+// is false.
+func parseLimit(w http.ResponseWriter, r *http.Request, defaultLimit, maxLimit int) (limit int, ok bool) {
+	value := r.URL.Query().Get("limit")
+	if value == "" {
+		return defaultLimit, true
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 1 || limit > maxLimit {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxLimit))
+		return 0, false
+	}
+	return limit, true
+}
+
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		slog.Warn("Failed to write response", "error", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+// Run serves the API on the configured address until ctx is canceled.
+func Run(ctx context.Context, cfg config.Config) error {
+	elasticsearchClient, err := elasticsearch.NewClient(cfg.Elasticsearch)
+	if err != nil {
+		return fmt.Errorf("failed to create elasticsearch client: %w", err)
+	}
+
+	netflixStore, err := titles.OpenStore(ctx, cfg.Sync.Store, cfg.Firestore, "netflix")
+	if err != nil {
+		return fmt.Errorf("failed to open availability store: %w", err)
+	}
+	defer netflixStore.Close()
+
+	reports, err := titles.OpenReportStore(ctx, cfg.Sync.ReportStore(), cfg.Firestore)
+	if err != nil {
+		return fmt.Errorf("failed to open sync report store: %w", err)
+	}
+	if reports != nil {
+		defer reports.Close()
+	}
+
+	server := &http.Server{
+		Addr:              cfg.Server.Addr,
+		Handler:           NewServer(elasticsearch.NewRepository(elasticsearchClient, cfg.Bulk), netflixStore, reports),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		slog.Info("Serving API", "addr", cfg.Server.Addr)
+		errs <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return fmt.Errorf("failed to serve API: %w", err)
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("failed to shut down API: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,387 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jonwilberg/stream-finder/internal/availability"
+	"github.com/jonwilberg/stream-finder/internal/repos/elasticsearch"
+	"github.com/jonwilberg/stream-finder/internal/syncreport"
+	"github.com/jonwilberg/stream-finder/internal/titles"
+)
+
+// newElasticsearchServer fakes the get document and search APIs of the titles
+// index. Searches match the documents whose title holds the query.
+func newElasticsearchServer(t *testing.T, documents map[string]elasticsearch.TitleDocumentBody) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.URL.Path == "/titles/_search" {
+			var search struct {
+				Query struct {
+					Bool struct {
+						Must struct {
+							MatchPhrase struct {
+								Title string `json:"title"`
+							} `json:"match_phrase"`
+						} `json:"must"`
+					} `json:"bool"`
+				} `json:"query"`
+			}
+			json.NewDecoder(r.Body).Decode(&search)
+
+			hits := []map[string]any{}
+			for id, body := range documents {
+				if strings.Contains(strings.ToLower(body.Title), strings.ToLower(search.Query.Bool.Must.MatchPhrase.Title)) {
+					hits = append(hits, map[string]any{"_id": id, "_score": 1, "_source": body})
+				}
+			}
+			json.NewEncoder(w).Encode(map[string]any{"hits": map[string]any{"total": map[string]any{"value": len(hits)}, "hits": hits}})
+			return
+		}
+
+		id := r.URL.Path[len("/titles/_doc/"):]
+		if id == "tt500" {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"error":"boom"}`))
+			return
+		}
+
+		body, ok := documents[id]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]any{"_index": "titles", "_id": id, "found": false})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"_index": "titles", "_id": id, "found": true, "_source": body})
+	}))
+}
+
+func TestGetTitle(t *testing.T) {
+	elasticsearchServer := newElasticsearchServer(t, map[string]elasticsearch.TitleDocumentBody{
+		"tt1302006": {
+			TitleType:  "movie",
+			Title:      "The Irishman",
+			Year:       2019,
+			Genres:     []string{"Biography", "Crime", "Drama"},
+			NetflixIDs: []string{"Video:80175798", "Video:1"},
+		},
+		"tt0000001": {
+			TitleType: "short",
+			Title:     "Carmencita",
+			Year:      1894,
+		},
+	})
+	defer elasticsearchServer.Close()
+
+	client, err := elasticsearch.NewClient(elasticsearch.Config{URL: elasticsearchServer.URL, NoAuth: true})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+
+	ctx := context.Background()
+	added := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	store := availability.NewMemoryStore()
+	store.Upsert(ctx, []availability.Record{{
+		ID:    "Video:80175798",
+		Title: availability.Title{Title: "The Irishman", Year: 2019, UpdatedAt: added, NetflixGenres: []string{"5824"}},
+	}})
+	store.AppendHistory(ctx, []availability.Event{{TitleID: "Video:80175798", Type: availability.EventAdded, At: added}})
+
+	server := httptest.NewServer(NewServer(elasticsearch.NewRepository(client, elasticsearch.BulkConfig{}), store, nil))
+	defer server.Close()
+
+	tests := []struct {
+		name           string
+		path           string
+		expectedStatus int
+		check          func(t *testing.T, detail titles.TitleDetail)
+	}{
+		{
+			name:           "title on netflix",
+			path:           "/v1/titles/tt1302006",
+			expectedStatus: http.StatusOK,
+			check: func(t *testing.T, detail titles.TitleDetail) {
+				if detail.ID != "tt1302006" || detail.Title != "The Irishman" || detail.Year != 2019 {
+					t.Errorf("detail = %+v", detail)
+				}
+				if len(detail.Availability) != 1 {
+					t.Fatalf("availability = %+v, want only the stored Netflix title", detail.Availability)
+				}
+				got := detail.Availability[0]
+				if got.Provider != "netflix" || got.URL != "https://www.netflix.com/title/80175798" || !got.UpdatedAt.Equal(added) {
+					t.Errorf("availability = %+v", got)
+				}
+				if len(got.History) != 1 || got.History[0].Type != availability.EventAdded {
+					t.Errorf("history = %+v, want one added event", got.History)
+				}
+			},
+		},
+		{
+			name:           "title not on netflix",
+			path:           "/v1/titles/tt0000001",
+			expectedStatus: http.StatusOK,
+			check: func(t *testing.T, detail titles.TitleDetail) {
+				if detail.Title != "Carmencita" || detail.Availability == nil || len(detail.Availability) != 0 {
+					t.Errorf("detail = %+v, want empty availability", detail)
+				}
+			},
+		},
+		{
+			name:           "unknown title",
+			path:           "/v1/titles/tt9999999",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "invalid title ID",
+			path:           "/v1/titles/nm0000001",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "elasticsearch error",
+			path:           "/v1/titles/tt500",
+			expectedStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.Get(server.URL + tt.path)
+			if err != nil {
+				t.Fatalf("GET %s error = %v", tt.path, err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.expectedStatus {
+				t.Fatalf("GET %s status = %d, want %d", tt.path, resp.StatusCode, tt.expectedStatus)
+			}
+			if tt.check == nil {
+				return
+			}
+
+			var detail titles.TitleDetail
+			if err := json.NewDecoder(resp.Body).Decode(&detail); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			tt.check(t, detail)
+		})
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	elasticsearchServer := newElasticsearchServer(t, nil)
+	defer elasticsearchServer.Close()
+
+	client, err := elasticsearch.NewClient(elasticsearch.Config{URL: elasticsearchServer.URL, NoAuth: true})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	server := httptest.NewServer(NewServer(elasticsearch.NewRepository(client, elasticsearch.BulkConfig{}), availability.NewMemoryStore(), nil))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/v1/titles/nm0000001")
+	if err != nil {
+		t.Fatalf("GET error = %v", err)
+	}
+	resp.Body.Close()
+
+	resp, err = http.Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatalf("GET /metrics error = %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	want := `stream_finder_api_request_duration_seconds_count{code="400",method="get",route="/v1/titles/{tconst}"}`
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), want) {
+		t.Errorf("GET /metrics status = %d, want %s in:\n%s", resp.StatusCode, want, body)
+	}
+}
+
+func TestSearchTitles(t *testing.T) {
+	elasticsearchServer := newElasticsearchServer(t, map[string]elasticsearch.TitleDocumentBody{
+		"tt1302006": {TitleType: "movie", Title: "The Irishman", Year: 2019, Genres: []string{"Crime"}},
+		"tt0000001": {TitleType: "short", Title: "Carmencita", Year: 1894},
+	})
+	defer elasticsearchServer.Close()
+
+	client, err := elasticsearch.NewClient(elasticsearch.Config{URL: elasticsearchServer.URL, NoAuth: true})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	server := httptest.NewServer(NewServer(elasticsearch.NewRepository(client, elasticsearch.BulkConfig{}), availability.NewMemoryStore(), nil))
+	defer server.Close()
+
+	tests := []struct {
+		name           string
+		path           string
+		expectedStatus int
+		expectedIDs    []string
+	}{
+		{
+			name:           "match",
+			path:           "/v1/titles?q=irishman",
+			expectedStatus: http.StatusOK,
+			expectedIDs:    []string{"tt1302006"},
+		},
+		{
+			name:           "no match",
+			path:           "/v1/titles?q=nothing&limit=5",
+			expectedStatus: http.StatusOK,
+			expectedIDs:    []string{},
+		},
+		{
+			name:           "missing query",
+			path:           "/v1/titles?q=%20",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "invalid limit",
+			path:           "/v1/titles?q=irishman&limit=0",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.Get(server.URL + tt.path)
+			if err != nil {
+				t.Fatalf("GET %s error = %v", tt.path, err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.expectedStatus {
+				t.Fatalf("GET %s status = %d, want %d", tt.path, resp.StatusCode, tt.expectedStatus)
+			}
+			if tt.expectedIDs == nil {
+				return
+			}
+
+			var results []searchResult
+			if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+				t.Fatalf("failed to decode results: %v", err)
+			}
+			ids := []string{}
+			for _, result := range results {
+				ids = append(ids, result.ID)
+			}
+			if strings.Join(ids, ",") != strings.Join(tt.expectedIDs, ",") {
+				t.Errorf("GET %s = %v, want %v", tt.path, ids, tt.expectedIDs)
+			}
+		})
+	}
+
+	resp, err := http.Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatalf("GET /metrics error = %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	want := `stream_finder_search_duration_seconds_count{outcome="succeeded"} 2`
+	if !strings.Contains(string(body), want) {
+		t.Errorf("GET /metrics does not have %s in:\n%s", want, body)
+	}
+}
+
+func TestSyncRuns(t *testing.T) {
+	reports := syncreport.NewFileStore(filepath.Join(t.TempDir(), "sync_runs.jsonl"))
+	started := time.Date(2024, 3, 1, 4, 0, 0, 0, time.UTC)
+	for i, err := range []error{errors.New("netflix session expired"), nil} {
+		report := syncreport.New(started.Add(time.Duration(i) * 24 * time.Hour))
+		report.Netflix = syncreport.Counts{Fetched: 7000, Added: 12, Removed: 3}
+		report.Finish(report.StartedAt.Add(time.Hour), err)
+		if err := reports.Save(context.Background(), report); err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+	}
+
+	server := httptest.NewServer(NewServer(nil, availability.NewMemoryStore(), reports))
+	defer server.Close()
+
+	tests := []struct {
+		name           string
+		path           string
+		expectedStatus int
+		expectedIDs    []string
+		single         bool
+	}{
+		{
+			name:           "most recent first",
+			path:           "/v1/sync-runs",
+			expectedStatus: http.StatusOK,
+			expectedIDs:    []string{"20240302T040000.000Z", "20240301T040000.000Z"},
+		},
+		{
+			name:           "limit",
+			path:           "/v1/sync-runs?limit=1",
+			expectedStatus: http.StatusOK,
+			expectedIDs:    []string{"20240302T040000.000Z"},
+		},
+		{
+			name:           "invalid limit",
+			path:           "/v1/sync-runs?limit=0",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "single run",
+			path:           "/v1/sync-runs/20240301T040000.000Z",
+			expectedStatus: http.StatusOK,
+			expectedIDs:    []string{"20240301T040000.000Z"},
+			single:         true,
+		},
+		{
+			name:           "unknown run",
+			path:           "/v1/sync-runs/20200101T000000.000Z",
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.Get(server.URL + tt.path)
+			if err != nil {
+				t.Fatalf("GET %s error = %v", tt.path, err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.expectedStatus {
+				t.Fatalf("GET %s status = %d, want %d", tt.path, resp.StatusCode, tt.expectedStatus)
+			}
+			if tt.expectedIDs == nil {
+				return
+			}
+
+			var got []syncreport.Report
+			if tt.single {
+				got = make([]syncreport.Report, 1)
+				err = json.NewDecoder(resp.Body).Decode(&got[0])
+			} else {
+				err = json.NewDecoder(resp.Body).Decode(&got)
+			}
+			if err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+
+			var ids []string
+			for _, report := range got {
+				ids = append(ids, report.ID)
+			}
+			if strings.Join(ids, ",") != strings.Join(tt.expectedIDs, ",") {
+				t.Errorf("GET %s returned runs %v, want %v", tt.path, ids, tt.expectedIDs)
+			}
+			if got[0].ID == "20240301T040000.000Z" && (got[0].Status != syncreport.StatusFailed || got[0].Error != "netflix session expired") {
+				t.Errorf("failed run = %+v", got[0])
+			}
+		})
+	}
+}
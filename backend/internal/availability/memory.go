@@ -0,0 +1,102 @@
+package availability
+
+import (
+	"context"
+	"slices"
+	"sync"
+)
+
+// MemoryStore keeps everything in memory, for local runs and tests.
+type MemoryStore struct {
+	mu      sync.Mutex
+	titles  map[string]Title
+	history []Event
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		titles: make(map[string]Title),
+	}
+}
+
+func (s *MemoryStore) ReadIDs(ctx context.Context) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := make([]string, 0, len(s.titles))
+	for id := range s.titles {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids, nil
+}
+
+// Get returns the title with the given ID, if it is stored.
+func (s *MemoryStore) Get(id string) (Title, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	title, ok := s.titles[id]
+	return title, ok
+}
+
+func (s *MemoryStore) GetMany(ctx context.Context, ids []string) ([]Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var records []Record
+	for _, id := range ids {
+		if title, ok := s.titles[id]; ok {
+			records = append(records, Record{ID: id, Title: title})
+		}
+	}
+	return records, nil
+}
+
+func (s *MemoryStore) Upsert(ctx context.Context, records []Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, record := range records {
+		s.titles[record.ID] = record.Title
+	}
+	return nil
+}
+
+func (s *MemoryStore) Delete(ctx context.Context, ids []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, id := range ids {
+		delete(s.titles, id)
+	}
+	return nil
+}
+
+func (s *MemoryStore) AppendHistory(ctx context.Context, events []Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.history = append(s.history, events...)
+	return nil
+}
+
+func (s *MemoryStore) History(ctx context.Context, titleID string) ([]Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var events []Event
+	for _, event := range s.history {
+		if event.TitleID == titleID {
+			events = append(events, event)
+		}
+	}
+	slices.SortStableFunc(events, func(a, b Event) int {
+		return a.At.Compare(b.At)
+	})
+	return events, nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
@@ -0,0 +1,52 @@
+// Package availability stores which titles are available on a streaming
+// provider and when they were added or removed.
+package availability
+
+import (
+	"context"
+	"time"
+)
+
+type Title struct {
+	Title         string    `firestore:"title"`
+	Year          int       `firestore:"year"`
+	UpdatedAt     time.Time `firestore:"updated_at"`
+	OriginalTitle string    `firestore:"original_title"`
+	IsAdult       bool      `firestore:"is_adult"`
+	Genres        []string  `firestore:"genres"`
+	TitleType     string    `firestore:"title_type"`
+	NetflixGenres []string  `firestore:"netflix_genres"`
+}
+
+// Record is a title together with its provider ID.
+type Record struct {
+	ID    string
+	Title Title
+}
+
+type EventType string
+
+const (
+	EventAdded   EventType = "added"
+	EventRemoved EventType = "removed"
+)
+
+type Event struct {
+	TitleID string    `firestore:"title_id"`
+	Type    EventType `firestore:"type"`
+	At      time.Time `firestore:"at"`
+}
+
+// Store holds the titles available on one provider.
+type Store interface {
+	ReadIDs(ctx context.Context) ([]string, error)
+	// GetMany returns the records with the given IDs, in the same order. IDs
+	// that are not stored are left out.
+	GetMany(ctx context.Context, ids []string) ([]Record, error)
+	Upsert(ctx context.Context, records []Record) error
+	Delete(ctx context.Context, ids []string) error
+	AppendHistory(ctx context.Context, events []Event) error
+	// History returns the events of a title, oldest first.
+	History(ctx context.Context, titleID string) ([]Event, error)
+	Close() error
+}
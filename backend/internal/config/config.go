@@ -0,0 +1,185 @@
+// Package config loads the backend configuration from defaults, a YAML or
+// TOML file, the environment and command line flags.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jonwilberg/stream-finder/internal/metrics"
+	"github.com/jonwilberg/stream-finder/internal/repos/elasticsearch"
+	"github.com/jonwilberg/stream-finder/internal/repos/firestore"
+	"github.com/jonwilberg/stream-finder/internal/repos/imdb"
+	"github.com/jonwilberg/stream-finder/internal/repos/netflix"
+	"github.com/jonwilberg/stream-finder/internal/telemetry"
+	"github.com/jonwilberg/stream-finder/pkg/logging"
+)
+
+// Command is a subcommand of the backend, which decides what has to be
+// configured.
+type Command string
+
+const (
+	// CommandUpdate syncs titles from Netflix and IMDb.
+	CommandUpdate Command = "update"
+	// CommandServe serves the API.
+	CommandServe Command = "serve"
+	// CommandConfig prints the configuration.
+	CommandConfig Command = "config"
+	// CommandReports prints the reports of recent sync runs.
+	CommandReports Command = "reports"
+)
+
+// Config is the configuration of the backend. Every section is passed to the
+// constructor of the repository it configures.
+//
+// Fields are tagged with their key in the config file, their environment
+// variable and the usage of their flag. The flag is named after the section
+// and key, e.g. -elasticsearch-url, unless a flag tag names it. Secrets are
+// not settable by flag and are redacted when the config is printed.
+type Config struct {
+	Netflix       netflix.Config           `config:"netflix"`
+	IMDb          imdb.Config              `config:"imdb"`
+	Elasticsearch elasticsearch.Config     `config:"elasticsearch"`
+	Bulk          elasticsearch.BulkConfig `config:"bulk"`
+	Firestore     firestore.Config         `config:"firestore"`
+	Sync          SyncConfig               `config:"sync"`
+	Server        ServerConfig             `config:"server"`
+	Telemetry     telemetry.Config         `config:"telemetry"`
+	Metrics       metrics.Config           `config:"metrics"`
+	Log           logging.Config           `config:"log"`
+}
+
+// SyncConfig configures the sync run of the update command.
+type SyncConfig struct {
+	// Store selects where Netflix availability is kept, see titles.OpenStore.
+	Store string `config:"store" env:"STREAM_FINDER_STORE" flag:"store" usage:"availability store: firestore, memory, sqlite:<path> or a postgres:// URL"`
+	// PruneDryRun only counts the stale titles instead of deleting them.
+	PruneDryRun bool `config:"prune_dry_run" flag:"prune-dry-run" usage:"count stale titles without deleting them"`
+	// PruneMaxRatio is the largest share of the index that may be deleted as
+	// stale. A higher share usually means the sync itself went wrong.
+	PruneMaxRatio float64 `config:"prune_max_ratio" flag:"prune-max-ratio" usage:"largest share of the index that may be deleted as stale"`
+	// Reports selects where sync run reports are kept, see
+	// titles.OpenReportStore. If it is empty they follow Store, see
+	// ReportStore.
+	Reports string `config:"reports" env:"STREAM_FINDER_REPORTS" flag:"reports" usage:"sync report store: firestore, file:<path> or none (default: firestore if the availability store is)"`
+}
+
+// ReportStore returns the spec of the sync report store. Unless Reports is
+// set, reports are kept in Firestore if availability is, and not kept
+// otherwise, so local runs do not need Firestore.
+func (c SyncConfig) ReportStore() string {
+	if c.Reports != "" {
+		return c.Reports
+	}
+	if c.Store == "" || c.Store == "firestore" {
+		return "firestore"
+	}
+	return "none"
+}
+
+// ServerConfig configures the API server of the serve command.
+type ServerConfig struct {
+	Addr string `config:"addr" env:"STREAM_FINDER_ADDR" flag:"addr" usage:"address the API listens on"`
+}
+
+func Default() Config {
+	return Config{
+		Bulk: elasticsearch.DefaultBulkConfig(),
+		Sync: SyncConfig{
+			Store:         "firestore",
+			PruneMaxRatio: 0.05,
+		},
+		Server: ServerConfig{
+			Addr: ":8080",
+		},
+		Telemetry: telemetry.Config{
+			Exporter: telemetry.ExporterNone,
+		},
+		Metrics: metrics.Config{
+			Job: metrics.DefaultJob,
+		},
+		Log: logging.Config{
+			Progress:         logging.ProgressAuto,
+			ProgressInterval: 30 * time.Second,
+		},
+	}
+}
+
+// Validate checks that everything the command needs is configured.
+func (c Config) Validate(command Command) error {
+	switch command {
+	case CommandUpdate:
+		var errs []error
+		if err := c.Netflix.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("netflix: %w", err))
+		}
+		if err := c.IMDb.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("imdb: %w", err))
+		}
+		errs = append(errs, c.validateStorage()...)
+		if c.Sync.PruneMaxRatio < 0 || c.Sync.PruneMaxRatio > 1 {
+			errs = append(errs, fmt.Errorf("sync: prune_max_ratio must be between 0 and 1, got %g", c.Sync.PruneMaxRatio))
+		}
+		if err := c.Telemetry.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("telemetry: %w", err))
+		}
+		if err := c.Log.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("log: %w", err))
+		}
+		return errors.Join(errs...)
+	case CommandServe:
+		errs := c.validateStorage()
+		if c.Server.Addr == "" {
+			errs = append(errs, errors.New("server: addr is required"))
+		}
+		if err := c.Telemetry.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("telemetry: %w", err))
+		}
+		return errors.Join(errs...)
+	case CommandReports:
+		return errors.Join(c.validateFirestore(c.Sync.ReportStore())...)
+	case CommandConfig:
+		return nil
+	default:
+		return fmt.Errorf("unknown command %q", command)
+	}
+}
+
+// validateStorage checks the config of Elasticsearch, the availability store
+// and the report store, which every command that touches titles needs.
+func (c Config) validateStorage() []error {
+	var errs []error
+	if err := c.Elasticsearch.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("elasticsearch: %w", err))
+	}
+	return append(errs, c.validateFirestore(c.Sync.Store, c.Sync.ReportStore())...)
+}
+
+// validateFirestore checks that Firestore is configured if any of the store
+// specs selects it.
+func (c Config) validateFirestore(specs ...string) []error {
+	if c.Firestore.ProjectID != "" || c.Firestore.EmulatorHost != "" {
+		return nil
+	}
+	for _, spec := range specs {
+		if spec == "" || spec == "firestore" {
+			return []error{errors.New("firestore: project_id is required")}
+		}
+	}
+	return nil
+}
+
+// String formats the config as YAML, with secrets redacted.
+func (c Config) String() string {
+	var b strings.Builder
+	for _, section := range sections(&c) {
+		fmt.Fprintf(&b, "%s:\n", section.name)
+		for _, f := range section.fields {
+			fmt.Fprintf(&b, "    %s: %s\n", f.key, f.format())
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,255 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeFile(t *testing.T, name string, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func envFunc(env map[string]string) func(string) string {
+	return func(key string) string { return env[key] }
+}
+
+func TestLoad(t *testing.T) {
+	yamlFile := writeFile(t, "config.yaml", `
+elasticsearch:
+    url: http://file:9200
+    username: from-file
+sync:
+    store: memory
+    prune_max_ratio: 0.1
+`)
+	tomlFile := writeFile(t, "config.toml", `
+[elasticsearch]
+url = "http://toml:9200"
+no_auth = true
+
+[sync]
+prune_dry_run = true
+`)
+
+	tests := []struct {
+		name    string
+		args    []string
+		env     map[string]string
+		check   func(t *testing.T, cfg Config)
+		wantErr string
+	}{
+		{
+			name: "defaults",
+			check: func(t *testing.T, cfg Config) {
+				if !reflect.DeepEqual(cfg, Default()) {
+					t.Errorf("Load() = %+v, want defaults", cfg)
+				}
+				// A default filter would prune titles indexed before it
+				// existed on the first run after an upgrade.
+				if cfg.IMDb.TitleTypes != nil || cfg.IMDb.Adult != "" || cfg.IMDb.MinVotes != 0 || cfg.IMDb.Years != nil {
+					t.Errorf("Load() IMDb = %+v, want every title kept by default", cfg.IMDb)
+				}
+			},
+		},
+		{
+			name: "yaml file",
+			args: []string{"-config", yamlFile},
+			check: func(t *testing.T, cfg Config) {
+				if cfg.Elasticsearch.URL != "http://file:9200" || cfg.Sync.Store != "memory" || cfg.Sync.PruneMaxRatio != 0.1 {
+					t.Errorf("Load() = %+v, want values from file", cfg)
+				}
+			},
+		},
+		{
+			name: "toml file from environment",
+			env:  map[string]string{"STREAM_FINDER_CONFIG": tomlFile},
+			check: func(t *testing.T, cfg Config) {
+				if cfg.Elasticsearch.URL != "http://toml:9200" || !cfg.Elasticsearch.NoAuth || !cfg.Sync.PruneDryRun {
+					t.Errorf("Load() = %+v, want values from file", cfg)
+				}
+			},
+		},
+		{
+			name: "environment overrides file and flags override environment",
+			args: []string{"-config", yamlFile, "-elasticsearch-url", "http://flag:9200", "-prune-dry-run"},
+			env: map[string]string{
+				"ELASTICSEARCH_URL":      "http://env:9200",
+				"ELASTICSEARCH_USERNAME": "from-env",
+				"NETFLIX_ID":             "netflix-id",
+			},
+			check: func(t *testing.T, cfg Config) {
+				if cfg.Elasticsearch.URL != "http://flag:9200" {
+					t.Errorf("Elasticsearch.URL = %q, want the flag", cfg.Elasticsearch.URL)
+				}
+				if cfg.Elasticsearch.Username != "from-env" {
+					t.Errorf("Elasticsearch.Username = %q, want the environment", cfg.Elasticsearch.Username)
+				}
+				if cfg.Netflix.NetflixID != "netflix-id" || !cfg.Sync.PruneDryRun || cfg.Sync.Store != "memory" {
+					t.Errorf("Load() = %+v", cfg)
+				}
+			},
+		},
+		{
+			name: "lists from environment and flags",
+			args: []string{"-imdb-years", "1990-1999,2010-"},
+			env:  map[string]string{"IMDB_TITLE_TYPES": "movie,tvSeries"},
+			check: func(t *testing.T, cfg Config) {
+				if !reflect.DeepEqual(cfg.IMDb.TitleTypes, []string{"movie", "tvSeries"}) || !reflect.DeepEqual(cfg.IMDb.Years, []string{"1990-1999", "2010-"}) {
+					t.Errorf("IMDb = %+v, want title types from the environment and years from the flag", cfg.IMDb)
+				}
+			},
+		},
+		{
+			name:    "unknown setting in file",
+			args:    []string{"-config", writeFile(t, "typo.yaml", "sync:\n    prune_max_ration: 0.1\n")},
+			wantErr: "sync.prune_max_ration: unknown setting",
+		},
+		{
+			name:    "invalid environment value",
+			env:     map[string]string{"ELASTICSEARCH_NO_AUTH": "maybe"},
+			wantErr: "invalid ELASTICSEARCH_NO_AUTH",
+		},
+		{
+			name:    "secrets cannot be set by flag",
+			args:    []string{"-elasticsearch-password", "secret"},
+			wantErr: "flag provided but not defined",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := Load(CommandUpdate, tt.args, envFunc(tt.env))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Load() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			tt.check(t, cfg)
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := Default()
+	valid.Netflix.NetflixID = "id"
+	valid.Netflix.SecureNetflixID = "secure"
+	valid.Elasticsearch.APIKey = "key"
+	valid.Firestore.ProjectID = "project"
+
+	if err := valid.Validate(CommandUpdate); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+
+	missing := Default()
+	err := missing.Validate(CommandUpdate)
+	if err == nil {
+		t.Fatalf("Validate() of the defaults should fail for %s", CommandUpdate)
+	}
+	for _, section := range []string{"netflix:", "elasticsearch:", "firestore:"} {
+		if !strings.Contains(err.Error(), section) {
+			t.Errorf("Validate() error = %v, want a %s error", err, section)
+		}
+	}
+
+	unknownExporter := valid
+	unknownExporter.Telemetry.Exporter = "jaeger"
+	if err := unknownExporter.Validate(CommandServe); err == nil || !strings.Contains(err.Error(), "telemetry:") {
+		t.Errorf("Validate() error = %v, want a telemetry: error", err)
+	}
+
+	badFilter := valid
+	badFilter.IMDb.Years = []string{"2000-1990"}
+	if err := badFilter.Validate(CommandUpdate); err == nil || !strings.Contains(err.Error(), "imdb:") {
+		t.Errorf("Validate() error = %v, want an imdb: error", err)
+	}
+
+	fileReports := Default()
+	fileReports.Sync.Reports = "file:sync_runs.jsonl"
+	if err := fileReports.Validate(CommandReports); err != nil {
+		t.Errorf("Validate() error = %v, reports kept in a file need no Firestore", err)
+	}
+	if err := missing.Validate(CommandReports); err == nil || !strings.Contains(err.Error(), "firestore:") {
+		t.Errorf("Validate() error = %v, want a firestore: error", err)
+	}
+
+	local := valid
+	local.Firestore.ProjectID = ""
+	local.Sync.Store = "sqlite:titles.db"
+	for _, command := range []Command{CommandUpdate, CommandServe, CommandReports} {
+		if err := local.Validate(command); err != nil {
+			t.Errorf("Validate(%s) error = %v, a local store needs no Firestore", command, err)
+		}
+	}
+	local.Sync.Reports = "firestore"
+	if err := local.Validate(CommandUpdate); err == nil || !strings.Contains(err.Error(), "firestore:") {
+		t.Errorf("Validate() error = %v, want a firestore: error for reports kept in Firestore", err)
+	}
+
+	if err := missing.Validate(CommandConfig); err != nil {
+		t.Errorf("Validate() error = %v, %s needs no settings", err, CommandConfig)
+	}
+}
+
+func TestString(t *testing.T) {
+	cfg := Default()
+	cfg.Netflix.NetflixID = "cookie-value"
+	cfg.Elasticsearch.APIKey = "api-key-value"
+	cfg.Elasticsearch.URL = "https://example.com:9200"
+
+	got := cfg.String()
+	for _, secret := range []string{"cookie-value", "api-key-value"} {
+		if strings.Contains(got, secret) {
+			t.Errorf("String() leaks secret %q:\n%s", secret, got)
+		}
+	}
+	if !strings.Contains(got, "api_key: REDACTED") || !strings.Contains(got, "url: https://example.com:9200") {
+		t.Errorf("String() = \n%s", got)
+	}
+	if !strings.Contains(got, `secure_netflix_id: ""`) {
+		t.Errorf("String() should not redact empty secrets:\n%s", got)
+	}
+
+	// The printed config is a valid config file.
+	path := writeFile(t, "printed.yaml", got)
+	loaded, err := Load(CommandConfig, []string{"-config", path}, envFunc(nil))
+	if err != nil {
+		t.Fatalf("Load() of printed config error = %v", err)
+	}
+	if loaded.Elasticsearch.URL != cfg.Elasticsearch.URL || loaded.Sync != cfg.Sync {
+		t.Errorf("Load() of printed config = %+v, want %+v", loaded, cfg)
+	}
+}
+
+func TestReportStore(t *testing.T) {
+	tests := []struct {
+		store   string
+		reports string
+		want    string
+	}{
+		{store: "", reports: "", want: "firestore"},
+		{store: "firestore", reports: "", want: "firestore"},
+		{store: "memory", reports: "", want: "none"},
+		{store: "sqlite:titles.db", reports: "", want: "none"},
+		{store: "memory", reports: "file:sync_runs.jsonl", want: "file:sync_runs.jsonl"},
+		{store: "firestore", reports: "none", want: "none"},
+	}
+
+	for _, tt := range tests {
+		sync := SyncConfig{Store: tt.store, Reports: tt.reports}
+		if got := sync.ReportStore(); got != tt.want {
+			t.Errorf("ReportStore() with store %q and reports %q = %q, want %q", tt.store, tt.reports, got, tt.want)
+		}
+	}
+}
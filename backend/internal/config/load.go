@@ -0,0 +1,278 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+const redacted = "REDACTED"
+
+// Load builds the config of a command from, in increasing precedence, the
+// defaults, the config file, the environment and the flags in args. The file
+// is given by the -config flag or STREAM_FINDER_CONFIG, and is read as TOML if
+// it ends in .toml and as YAML otherwise.
+func Load(command Command, args []string, getenv func(string) string) (Config, error) {
+	cfg := Default()
+
+	fs := flag.NewFlagSet(string(command), flag.ContinueOnError)
+	configPath := fs.String("config", getenv("STREAM_FINDER_CONFIG"), "YAML or TOML config file")
+
+	var setFlags []func() error
+	for _, section := range sections(&cfg) {
+		for _, f := range section.fields {
+			if f.secret {
+				continue
+			}
+			set := func(value string) error {
+				setFlags = append(setFlags, func() error { return f.set(value) })
+				return nil
+			}
+			if f.value.Kind() == reflect.Bool {
+				fs.BoolFunc(f.flag, f.usage, set)
+			} else {
+				fs.Func(f.flag, f.usage, set)
+			}
+		}
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+	if fs.NArg() > 0 {
+		return Config{}, fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+
+	if *configPath != "" {
+		if err := loadFile(&cfg, *configPath); err != nil {
+			return Config{}, err
+		}
+	}
+
+	for _, section := range sections(&cfg) {
+		for _, f := range section.fields {
+			if f.env == "" {
+				continue
+			}
+			if value := getenv(f.env); value != "" {
+				if err := f.set(value); err != nil {
+					return Config{}, fmt.Errorf("invalid %s: %w", f.env, err)
+				}
+			}
+		}
+	}
+
+	// The flags were parsed before the file was read, so they are set last.
+	for _, set := range setFlags {
+		if err := set(); err != nil {
+			return Config{}, err
+		}
+	}
+
+	return cfg, nil
+}
+
+func loadFile(cfg *Config, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var values map[string]any
+	if filepath.Ext(path) == ".toml" {
+		err = toml.Unmarshal(data, &values)
+	} else {
+		err = yaml.Unmarshal(data, &values)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	var errs []error
+	known := make(map[string]bool)
+	for _, section := range sections(cfg) {
+		known[section.name] = true
+
+		raw, ok := values[section.name]
+		if !ok {
+			continue
+		}
+		sectionValues, ok := raw.(map[string]any)
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: expected a table of settings", section.name))
+			continue
+		}
+
+		sectionKeys := make(map[string]bool)
+		for _, f := range section.fields {
+			sectionKeys[f.key] = true
+			if value, ok := sectionValues[f.key]; ok {
+				if err := f.setAny(value); err != nil {
+					errs = append(errs, fmt.Errorf("%s.%s: %w", section.name, f.key, err))
+				}
+			}
+		}
+		for key := range sectionValues {
+			if !sectionKeys[key] {
+				errs = append(errs, fmt.Errorf("%s.%s: unknown setting", section.name, key))
+			}
+		}
+	}
+	for name := range values {
+		if !known[name] {
+			errs = append(errs, fmt.Errorf("%s: unknown section", name))
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return nil
+}
+
+type section struct {
+	name   string
+	fields []field
+}
+
+type field struct {
+	key    string
+	env    string
+	flag   string
+	usage  string
+	secret bool
+	value  reflect.Value
+}
+
+// sections lists the tagged fields of cfg, grouped by section. The values are
+// addressable, so setting them changes cfg.
+func sections(cfg *Config) []section {
+	var result []section
+
+	root := reflect.ValueOf(cfg).Elem()
+	for i := 0; i < root.NumField(); i++ {
+		s := section{name: root.Type().Field(i).Tag.Get("config")}
+
+		value := root.Field(i)
+		for j := 0; j < value.NumField(); j++ {
+			structField := value.Type().Field(j)
+			key := structField.Tag.Get("config")
+			if key == "" {
+				continue
+			}
+
+			flagName := structField.Tag.Get("flag")
+			if flagName == "" {
+				flagName = s.name + "-" + strings.ReplaceAll(key, "_", "-")
+			}
+
+			s.fields = append(s.fields, field{
+				key:    key,
+				env:    structField.Tag.Get("env"),
+				flag:   flagName,
+				usage:  structField.Tag.Get("usage"),
+				secret: structField.Tag.Get("secret") == "true",
+				value:  value.Field(j),
+			})
+		}
+
+		result = append(result, s)
+	}
+
+	return result
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// set parses a value given as a string, from a flag or the environment. Lists
+// are separated by commas.
+func (f field) set(value string) error {
+	switch {
+	case f.value.Type() == durationType:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		f.value.SetInt(int64(d))
+	case f.value.Kind() == reflect.String:
+		f.value.SetString(value)
+	case f.value.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		f.value.SetBool(b)
+	case f.value.CanInt():
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		f.value.SetInt(n)
+	case f.value.CanFloat():
+		x, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		f.value.SetFloat(x)
+	case f.value.Kind() == reflect.Slice && f.value.Type().Elem().Kind() == reflect.String:
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		f.value.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported setting type %s", f.value.Type())
+	}
+	return nil
+}
+
+// setAny sets a value decoded from the config file.
+func (f field) setAny(value any) error {
+	if items, ok := value.([]any); ok {
+		if f.value.Kind() != reflect.Slice {
+			return fmt.Errorf("expected a single value, got a list")
+		}
+		values := make([]string, 0, len(items))
+		for _, item := range items {
+			values = append(values, fmt.Sprint(item))
+		}
+		f.value.Set(reflect.ValueOf(values))
+		return nil
+	}
+	return f.set(fmt.Sprint(value))
+}
+
+// format returns the value as YAML, or REDACTED for a secret that is set.
+func (f field) format() string {
+	if f.secret && !f.value.IsZero() {
+		return redacted
+	}
+	var data []byte
+	var err error
+	if f.value.Kind() == reflect.Slice {
+		data, err = yaml.Marshal(yamlFlow{f.value.Interface()})
+		data = []byte(strings.TrimPrefix(string(data), "v:"))
+	} else {
+		data, err = yaml.Marshal(f.value.Interface())
+	}
+	if err != nil {
+		return strconv.Quote(fmt.Sprint(f.value.Interface()))
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// yamlFlow marshals a list on a single line.
+type yamlFlow struct {
+	V any `yaml:"v,flow"`
+}
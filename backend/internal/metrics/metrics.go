@@ -0,0 +1,158 @@
+// Package metrics defines the Prometheus metrics of the backend. The API
+// serves them on /metrics. Sync runs do not live long enough to be scraped,
+// so they push their metrics to a Pushgateway when they end.
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/push"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// DefaultJob is the job label metrics are pushed under unless Config.Job is set.
+const DefaultJob = "stream_finder_update"
+
+// Config configures the export of metrics from sync runs.
+type Config struct {
+	// PushGatewayURL is the Pushgateway that sync runs push their metrics to.
+	// Metrics are not pushed if it is empty.
+	PushGatewayURL string `config:"pushgateway_url" env:"PROMETHEUS_PUSHGATEWAY_URL" usage:"Pushgateway that sync runs push their metrics to"`
+	// Job is the job label the metrics are pushed under.
+	Job string `config:"job" env:"PROMETHEUS_JOB" usage:"job label that metrics are pushed under"`
+}
+
+var registry = prometheus.NewRegistry()
+
+const syncLastSuccessName = "stream_finder_sync_last_success_timestamp_seconds"
+
+var (
+	// TitlesFetched counts the titles fetched from each provider.
+	TitlesFetched = promauto.With(registry).NewCounterVec(prometheus.CounterOpts{
+		Name: "stream_finder_titles_fetched_total",
+		Help: "Titles fetched from a provider.",
+	}, []string{"provider"})
+
+	// DecodeFailures counts the IMDb dataset rows that could not be decoded.
+	DecodeFailures = promauto.With(registry).NewCounter(prometheus.CounterOpts{
+		Name: "stream_finder_imdb_decode_failures_total",
+		Help: "IMDb dataset rows that could not be decoded.",
+	})
+
+	// BulkIndexFailures counts the documents that failed to index after all
+	// retries, by the status Elasticsearch rejected them with.
+	BulkIndexFailures = promauto.With(registry).NewCounterVec(prometheus.CounterOpts{
+		Name: "stream_finder_bulk_index_failures_total",
+		Help: "Documents that failed to index after all retries.",
+	}, []string{"status"})
+
+	// TitlesDeleted counts the titles deleted from each store: stale titles
+	// from Elasticsearch and removed titles from the availability store.
+	TitlesDeleted = promauto.With(registry).NewCounterVec(prometheus.CounterOpts{
+		Name: "stream_finder_titles_deleted_total",
+		Help: "Titles deleted by sync runs.",
+	}, []string{"store"})
+
+	// NetflixResponses counts the responses from Netflix by status code.
+	NetflixResponses = promauto.With(registry).NewCounterVec(prometheus.CounterOpts{
+		Name: "stream_finder_netflix_http_responses_total",
+		Help: "HTTP responses from Netflix.",
+	}, []string{"code", "method"})
+
+	// SyncStageDuration observes how long each stage of a sync run takes.
+	SyncStageDuration = promauto.With(registry).NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "stream_finder_sync_stage_duration_seconds",
+		Help:    "Duration of the stages of a sync run.",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 14),
+	}, []string{"stage", "outcome"})
+
+	// SyncLastSuccess is the time the last sync run succeeded. Failed runs do
+	// not push it, so the Pushgateway keeps the time of the last success.
+	SyncLastSuccess = promauto.With(registry).NewGauge(prometheus.GaugeOpts{
+		Name: syncLastSuccessName,
+		Help: "Unix time the last sync run succeeded.",
+	})
+
+	// SearchDuration observes the latency of title searches in Elasticsearch.
+	SearchDuration = promauto.With(registry).NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "stream_finder_search_duration_seconds",
+		Help:    "Latency of title searches.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"outcome"})
+
+	// RequestDuration observes the latency of the API by route.
+	RequestDuration = promauto.With(registry).NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "stream_finder_api_request_duration_seconds",
+		Help:    "Latency of API requests.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "code", "method"})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// Handler serves the metrics in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{Registry: registry})
+}
+
+// InstrumentNetflixTransport counts the responses of next in NetflixResponses.
+func InstrumentNetflixTransport(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return promhttp.InstrumentRoundTripperCounter(NetflixResponses, next)
+}
+
+// InstrumentHandler observes the latency of handler in RequestDuration under
+// the given route.
+func InstrumentHandler(route string, handler http.HandlerFunc) http.Handler {
+	observer := RequestDuration.MustCurryWith(prometheus.Labels{"route": route})
+	return promhttp.InstrumentHandlerDuration(observer, handler)
+}
+
+// Push sends the metrics of a sync run to the Pushgateway. After a successful
+// run they replace all metrics of the job. After a failed run SyncLastSuccess
+// is left out and the other metrics are added, so the time of the last
+// success is kept. It does nothing if no Pushgateway is configured.
+func Push(ctx context.Context, config Config, succeeded bool) error {
+	if config.PushGatewayURL == "" {
+		return nil
+	}
+
+	job := config.Job
+	if job == "" {
+		job = DefaultJob
+	}
+	pusher := push.New(config.PushGatewayURL, job)
+	var err error
+	if succeeded {
+		err = pusher.Gatherer(registry).PushContext(ctx)
+	} else {
+		err = pusher.Gatherer(withoutLastSuccess).AddContext(ctx)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to push metrics: %w", err)
+	}
+	return nil
+}
+
+// withoutLastSuccess gathers the metrics of the registry except
+// SyncLastSuccess.
+var withoutLastSuccess = prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+	families, err := registry.Gather()
+	return slices.DeleteFunc(families, func(family *dto.MetricFamily) bool {
+		return family.GetName() == syncLastSuccessName
+	}), err
+})
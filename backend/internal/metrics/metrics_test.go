@@ -0,0 +1,55 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPush(t *testing.T) {
+	var method, path, body string
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		method, path, body = r.Method, r.URL.Path, string(data)
+	}))
+	defer gateway.Close()
+
+	DecodeFailures.Add(3)
+	SyncLastSuccess.SetToCurrentTime()
+
+	tests := []struct {
+		name            string
+		succeeded       bool
+		wantMethod      string
+		wantLastSuccess bool
+	}{
+		{name: "successful run", succeeded: true, wantMethod: http.MethodPut, wantLastSuccess: true},
+		{name: "failed run", succeeded: false, wantMethod: http.MethodPost, wantLastSuccess: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Push(context.Background(), Config{PushGatewayURL: gateway.URL}, tt.succeeded); err != nil {
+				t.Fatalf("Push() error = %v", err)
+			}
+			if method != tt.wantMethod || path != "/metrics/job/"+DefaultJob {
+				t.Errorf("Push() sent %s %s, want %s to the job", method, path, tt.wantMethod)
+			}
+			if !strings.Contains(body, "stream_finder_imdb_decode_failures_total") {
+				t.Errorf("Push() did not send the decode failures")
+			}
+			if got := strings.Contains(body, syncLastSuccessName); got != tt.wantLastSuccess {
+				t.Errorf("Push() sent the last success time = %v, want %v", got, tt.wantLastSuccess)
+			}
+		})
+	}
+}
+
+func TestPushWithoutGateway(t *testing.T) {
+	if err := Push(context.Background(), Config{}, true); err != nil {
+		t.Errorf("Push() without a Pushgateway error = %v", err)
+	}
+}
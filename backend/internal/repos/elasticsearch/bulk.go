@@ -0,0 +1,301 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8/esutil"
+	"github.com/jonwilberg/stream-finder/internal/metrics"
+	"github.com/jonwilberg/stream-finder/internal/telemetry"
+	"github.com/jonwilberg/stream-finder/pkg/logging"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	tracer = otel.Tracer("github.com/jonwilberg/stream-finder/internal/repos/elasticsearch")
+	meter  = otel.Meter("github.com/jonwilberg/stream-finder/internal/repos/elasticsearch")
+
+	bulkDocuments, _ = meter.Int64Counter("elasticsearch.bulk.documents",
+		metric.WithDescription("Documents sent in bulk requests, by outcome"),
+	)
+)
+
+// BulkIndexFailure is a document that Elasticsearch did not index. Status is
+// 0 if the whole bulk request failed rather than the single document.
+type BulkIndexFailure struct {
+	DocumentID string `json:"document_id"`
+	Status     int    `json:"status"`
+	Type       string `json:"type,omitempty"`
+	Reason     string `json:"reason"`
+
+	document TitleDocument
+}
+
+func (f BulkIndexFailure) retryable() bool {
+	switch f.Status {
+	case 0, http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// BulkIndexResult counts the documents of a BulkIndexTitles call that were
+// indexed and that failed after all retries.
+type BulkIndexResult struct {
+	Indexed int
+	// Created and Updated split Indexed into the documents that were new to
+	// the index and those that replaced an existing document.
+	Created int
+	Updated int
+	Failed  int
+	// FailedIDs are the IDs of the documents that failed, which keep the
+	// version they had before the call.
+	FailedIDs []string
+}
+
+// BulkIndexTitles indexes the documents, retrying those rejected with a
+// retryable status. Documents that still fail are written to the dead-letter
+// file, and an error is returned if they exceed the failure ratio.
+//
+// In ingest mode the index is tuned for the load while it runs, see
+// BulkConfig.
+func (r *Repository) BulkIndexTitles(ctx context.Context, titleDocs []TitleDocument) (result BulkIndexResult, err error) {
+	ctx, span := tracer.Start(ctx, "elasticsearch.BulkIndexTitles", trace.WithAttributes(
+		attribute.Int("elasticsearch.documents", len(titleDocs)),
+		attribute.Bool("elasticsearch.ingest_mode", r.bulk.IngestMode),
+	))
+	defer func() { telemetry.End(span, err) }()
+
+	if !r.bulk.IngestMode {
+		return r.bulkIndexTitles(ctx, titleDocs)
+	}
+
+	previous, err := r.beginIngest(ctx, "titles")
+	if err != nil {
+		return BulkIndexResult{}, err
+	}
+	defer func() {
+		// The settings are restored even if the load failed or was canceled.
+		if endErr := r.endIngest(context.WithoutCancel(ctx), "titles", previous, err == nil); endErr != nil {
+			err = errors.Join(err, fmt.Errorf("failed to end ingest mode: %w", endErr))
+		}
+	}()
+
+	return r.bulkIndexTitles(ctx, titleDocs)
+}
+
+func (r *Repository) bulkIndexTitles(ctx context.Context, titleDocs []TitleDocument) (BulkIndexResult, error) {
+	var result BulkIndexResult
+	var failed []BulkIndexFailure
+	pending := titleDocs
+
+	for attempt := 0; len(pending) > 0; attempt++ {
+		if attempt > 0 {
+			backoff := time.Duration(1<<(attempt-1)) * time.Second
+			slog.Warn("Retrying documents that failed to index",
+				"count", len(pending),
+				"attempt", attempt,
+				"backoff", backoff,
+			)
+			select {
+			case <-ctx.Done():
+				return BulkIndexResult{}, ctx.Err()
+			case <-time.After(backoff):
+			}
+		}
+
+		failures, err := r.bulkIndex(ctx, pending, attempt, &result)
+		if err != nil {
+			return BulkIndexResult{}, err
+		}
+
+		pending = nil
+		for _, failure := range failures {
+			if failure.retryable() && attempt < r.maxRetries {
+				pending = append(pending, failure.document)
+			} else {
+				failed = append(failed, failure)
+			}
+		}
+	}
+
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Int("elasticsearch.failed", len(failed)))
+	result.Indexed = len(titleDocs) - len(failed)
+	result.Failed = len(failed)
+	if len(failed) == 0 {
+		return result, nil
+	}
+
+	for _, failure := range failed {
+		result.FailedIDs = append(result.FailedIDs, failure.DocumentID)
+		metrics.BulkIndexFailures.WithLabelValues(strconv.Itoa(failure.Status)).Inc()
+	}
+	for _, failure := range failed[:min(len(failed), 10)] {
+		slog.Warn("Failed to index document",
+			"id", failure.DocumentID,
+			"status", failure.Status,
+			"type", failure.Type,
+			"reason", failure.Reason,
+		)
+	}
+
+	if err := writeDeadLetters(r.deadLetterPath, failed); err != nil {
+		return result, err
+	}
+	slog.Warn("Wrote documents that failed to index", "count", len(failed), "path", r.deadLetterPath)
+
+	if ratio := float64(len(failed)) / float64(len(titleDocs)); ratio > r.maxFailureRatio {
+		return result, fmt.Errorf("%d of %d documents failed to index, see %s", len(failed), len(titleDocs), r.deadLetterPath)
+	}
+
+	return result, nil
+}
+
+// bulkIndex sends the documents in bulk requests and returns those that
+// failed. The documents that were indexed are added to the Created and Updated
+// counts of result.
+func (r *Repository) bulkIndex(ctx context.Context, titleDocs []TitleDocument, attempt int, result *BulkIndexResult) (failures []BulkIndexFailure, err error) {
+	ctx, span := tracer.Start(ctx, "elasticsearch.bulkIndex", trace.WithAttributes(
+		attribute.Int("elasticsearch.attempt", attempt),
+		attribute.Int("elasticsearch.documents", len(titleDocs)),
+	))
+	defer func() { telemetry.End(span, err) }()
+
+	var (
+		mu        sync.Mutex
+		flushErr  error
+		succeeded = make([]bool, len(titleDocs))
+		failed    = make([]bool, len(titleDocs))
+		created   = make([]bool, len(titleDocs))
+	)
+
+	bulkIndexerConfig := esutil.BulkIndexerConfig{
+		Index:         "titles",
+		Client:        r.client,
+		NumWorkers:    r.bulk.Workers,
+		FlushBytes:    r.bulk.FlushBytes,
+		FlushInterval: r.bulk.FlushInterval,
+		OnError: func(ctx context.Context, err error) {
+			mu.Lock()
+			flushErr = err
+			mu.Unlock()
+		},
+	}
+
+	bi, err := esutil.NewBulkIndexer(bulkIndexerConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create bulk indexer: %w", err)
+	}
+
+	bar := logging.NewProgress("Indexing titles to Elasticsearch", len(titleDocs))
+
+	for i, doc := range titleDocs {
+		docJSON, err := json.Marshal(doc.Body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal document: %w", err)
+		}
+
+		err = bi.Add(ctx, esutil.BulkIndexerItem{
+			Action:     "index",
+			DocumentID: doc.ID,
+			Body:       bytes.NewReader(docJSON),
+			OnSuccess: func(_ context.Context, _ esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem) {
+				succeeded[i] = true
+				created[i] = res.Result == "created"
+			},
+			OnFailure: func(_ context.Context, _ esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem, err error) {
+				failure := BulkIndexFailure{
+					DocumentID: doc.ID,
+					Status:     res.Status,
+					Type:       res.Error.Type,
+					Reason:     res.Error.Reason,
+					document:   doc,
+				}
+				if err != nil {
+					failure.Reason = err.Error()
+				}
+
+				mu.Lock()
+				failures = append(failures, failure)
+				failed[i] = true
+				mu.Unlock()
+			},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to add document to bulk indexer: %w", err)
+		}
+		bar.Add(1)
+	}
+
+	bar.Finish()
+
+	if err := bi.Close(ctx); err != nil {
+		return nil, fmt.Errorf("failed to close bulk indexer: %w", err)
+	}
+
+	stats := bi.Stats()
+	slog.Info("Bulk indexed titles",
+		"added", stats.NumAdded,
+		"indexed", stats.NumIndexed,
+		"failed", stats.NumFailed,
+		"requests", stats.NumRequests,
+		"flushed_bytes", stats.FlushedBytes,
+	)
+
+	// Documents in a bulk request that failed as a whole get no callback.
+	for i, doc := range titleDocs {
+		if succeeded[i] && created[i] {
+			result.Created++
+		} else if succeeded[i] {
+			result.Updated++
+		}
+		if succeeded[i] || failed[i] {
+			continue
+		}
+		reason := "bulk request failed"
+		if flushErr != nil {
+			reason = flushErr.Error()
+		}
+		failures = append(failures, BulkIndexFailure{
+			DocumentID: doc.ID,
+			Reason:     reason,
+			document:   doc,
+		})
+	}
+
+	span.SetAttributes(attribute.Int("elasticsearch.failures", len(failures)))
+	bulkDocuments.Add(ctx, int64(len(titleDocs)-len(failures)), metric.WithAttributes(attribute.String("outcome", "indexed")))
+	bulkDocuments.Add(ctx, int64(len(failures)), metric.WithAttributes(attribute.String("outcome", "failed")))
+
+	return failures, nil
+}
+
+func writeDeadLetters(path string, failures []BulkIndexFailure) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create dead-letter file: %w", err)
+	}
+	defer file.Close()
+
+	encoder := json.NewEncoder(file)
+	for _, failure := range failures {
+		if err := encoder.Encode(failure); err != nil {
+			return fmt.Errorf("failed to write dead-letter file: %w", err)
+		}
+	}
+
+	return nil
+}
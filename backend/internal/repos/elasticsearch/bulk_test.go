@@ -0,0 +1,365 @@
+package elasticsearch
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// newBulkServer fakes the Elasticsearch bulk API. Documents are indexed unless
+// their ID is in rejections, which maps the ID to the statuses it is rejected
+// with on consecutive attempts. Documents indexed before are updated.
+func newBulkServer(t *testing.T, rejections map[string][]int) *httptest.Server {
+	bulk := bulkHandler(t, rejections)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/_bulk") {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			return
+		}
+		bulk(w, r)
+	}))
+}
+
+func bulkHandler(t *testing.T, rejections map[string][]int) http.HandlerFunc {
+	var mu sync.Mutex
+	attempts := map[string]int{}
+	indexed := map[string]bool{}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		w.Header().Set("Content-Type", "application/json")
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		var items []map[string]any
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			var action struct {
+				Index struct {
+					ID string `json:"_id"`
+				} `json:"index"`
+			}
+			if err := json.Unmarshal(scanner.Bytes(), &action); err != nil {
+				t.Errorf("failed to parse bulk action: %v", err)
+			}
+			scanner.Scan()
+
+			id := action.Index.ID
+			item := map[string]any{"_id": id, "status": http.StatusCreated, "result": "created"}
+			if statuses := rejections[id]; attempts[id] < len(statuses) {
+				item["status"] = statuses[attempts[id]]
+				item["error"] = map[string]any{"type": "rejected", "reason": "rejected by test"}
+			} else if indexed[id] {
+				item["status"], item["result"] = http.StatusOK, "updated"
+			} else {
+				indexed[id] = true
+			}
+			attempts[id]++
+			items = append(items, map[string]any{"index": item})
+		}
+
+		json.NewEncoder(w).Encode(map[string]any{"errors": true, "items": items})
+	}
+}
+
+func TestBulkIndexTitles(t *testing.T) {
+	docs := []TitleDocument{
+		{ID: "tt1", Body: TitleDocumentBody{Title: "One"}},
+		{ID: "tt2", Body: TitleDocumentBody{Title: "Two"}},
+		{ID: "tt3", Body: TitleDocumentBody{Title: "Three"}},
+		{ID: "tt4", Body: TitleDocumentBody{Title: "Four"}},
+	}
+
+	tests := []struct {
+		name            string
+		rejections      map[string][]int
+		maxFailureRatio float64
+		expectedFailed  []string
+		wantErr         bool
+	}{
+		{
+			name: "all indexed",
+		},
+		{
+			name:       "retryable rejection succeeds on retry",
+			rejections: map[string][]int{"tt2": {http.StatusTooManyRequests}},
+		},
+		{
+			name:            "permanent rejection below threshold",
+			rejections:      map[string][]int{"tt3": {http.StatusBadRequest}},
+			maxFailureRatio: 0.5,
+			expectedFailed:  []string{"tt3"},
+		},
+		{
+			name:            "permanent rejection above threshold",
+			rejections:      map[string][]int{"tt3": {http.StatusBadRequest}},
+			maxFailureRatio: 0.1,
+			expectedFailed:  []string{"tt3"},
+			wantErr:         true,
+		},
+		{
+			name:            "retries exhausted",
+			rejections:      map[string][]int{"tt1": {429, 429}},
+			maxFailureRatio: 0.5,
+			expectedFailed:  []string{"tt1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newBulkServer(t, tt.rejections)
+			defer server.Close()
+
+			client, err := NewClient(Config{URL: server.URL, NoAuth: true})
+			if err != nil {
+				t.Fatalf("NewClient() error = %v", err)
+			}
+
+			deadLetterPath := filepath.Join(t.TempDir(), "dead_letter.jsonl")
+			repo := NewRepository(client, BulkConfig{},
+				WithMaxRetries(1),
+				WithMaxFailureRatio(tt.maxFailureRatio),
+				WithDeadLetterPath(deadLetterPath),
+			)
+
+			result, err := repo.BulkIndexTitles(context.Background(), docs)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("BulkIndexTitles() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if result.Indexed != len(docs)-len(tt.expectedFailed) || result.Failed != len(tt.expectedFailed) {
+				t.Errorf("BulkIndexTitles() = %+v, want %d failed", result, len(tt.expectedFailed))
+			}
+			if result.Created != result.Indexed || result.Updated != 0 {
+				t.Errorf("BulkIndexTitles() = %+v, want every indexed document created", result)
+			}
+
+			var failed []string
+			if data, err := os.ReadFile(deadLetterPath); err == nil {
+				for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+					var failure BulkIndexFailure
+					if err := json.Unmarshal([]byte(line), &failure); err != nil {
+						t.Fatalf("failed to parse dead letter %q: %v", line, err)
+					}
+					failed = append(failed, failure.DocumentID)
+				}
+			}
+
+			if strings.Join(failed, ",") != strings.Join(tt.expectedFailed, ",") {
+				t.Errorf("dead letters = %v, want %v", failed, tt.expectedFailed)
+			}
+		})
+	}
+}
+
+func TestBulkIndexTitlesIngestMode(t *testing.T) {
+	docs := []TitleDocument{
+		{ID: "tt1", Body: TitleDocumentBody{Title: "One"}},
+		{ID: "tt2", Body: TitleDocumentBody{Title: "Two"}},
+	}
+
+	tests := []struct {
+		name       string
+		settings   string
+		rejections map[string][]int
+		expected   []string
+		wantErr    bool
+	}{
+		{
+			name:     "restores settings after load",
+			settings: `{"titles":{"settings":{"index.refresh_interval":"5s","index.number_of_replicas":"1"}}}`,
+			expected: []string{
+				"GET /titles/_settings/index.refresh_interval,index.number_of_replicas",
+				`PUT /titles/_settings {"index.refresh_interval":"-1","index.number_of_replicas":"0"}`,
+				"POST /titles/_bulk",
+				"POST /titles/_forcemerge",
+				"GET /_tasks/node:1",
+				"GET /_tasks/node:1",
+				`PUT /titles/_settings {"index.refresh_interval":"5s","index.number_of_replicas":"1"}`,
+				"POST /titles/_refresh",
+			},
+		},
+		{
+			name:     "resets refreshes left disabled",
+			settings: `{"titles":{"settings":{"index.refresh_interval":"-1","index.number_of_replicas":"1"}}}`,
+			expected: []string{
+				"GET /titles/_settings/index.refresh_interval,index.number_of_replicas",
+				`PUT /titles/_settings {"index.refresh_interval":"-1","index.number_of_replicas":"0"}`,
+				"POST /titles/_bulk",
+				"POST /titles/_forcemerge",
+				"GET /_tasks/node:1",
+				"GET /_tasks/node:1",
+				`PUT /titles/_settings {"index.refresh_interval":null,"index.number_of_replicas":"1"}`,
+				"POST /titles/_refresh",
+			},
+		},
+		{
+			name:       "restores settings without merging after failed load",
+			settings:   `{"titles":{"settings":{"index.number_of_replicas":"2"}}}`,
+			rejections: map[string][]int{"tt1": {400}, "tt2": {400}},
+			expected: []string{
+				"GET /titles/_settings/index.refresh_interval,index.number_of_replicas",
+				`PUT /titles/_settings {"index.refresh_interval":"-1","index.number_of_replicas":"0"}`,
+				"POST /titles/_bulk",
+				`PUT /titles/_settings {"index.refresh_interval":null,"index.number_of_replicas":"2"}`,
+				"POST /titles/_refresh",
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				mu       sync.Mutex
+				requests []string
+				polls    int
+			)
+
+			bulk := bulkHandler(t, tt.rejections)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("X-Elastic-Product", "Elasticsearch")
+				w.Header().Set("Content-Type", "application/json")
+
+				request := r.Method + " " + r.URL.Path
+				if r.URL.Path == "/titles/_settings" && r.Method == http.MethodPut {
+					body, _ := io.ReadAll(r.Body)
+					request += " " + string(body)
+				}
+				mu.Lock()
+				requests = append(requests, request)
+				if r.URL.Path == "/_tasks/node:1" {
+					polls++
+				}
+				completed := polls > 1
+				mu.Unlock()
+
+				switch {
+				case r.URL.Path == "/titles/_bulk":
+					bulk(w, r)
+				case strings.HasPrefix(r.URL.Path, "/titles/_settings/") && r.Method == http.MethodGet:
+					w.Write([]byte(tt.settings))
+				case r.URL.Path == "/titles/_forcemerge":
+					if r.URL.Query().Get("max_num_segments") != "1" || r.URL.Query().Get("wait_for_completion") != "false" {
+						t.Errorf("unexpected force merge parameters %s", r.URL.RawQuery)
+					}
+					w.Write([]byte(`{"task":"node:1"}`))
+				case r.URL.Path == "/_tasks/node:1":
+					json.NewEncoder(w).Encode(map[string]any{"completed": completed})
+				default:
+					w.Write([]byte(`{"acknowledged":true}`))
+				}
+			}))
+			defer server.Close()
+
+			client, err := NewClient(Config{URL: server.URL, NoAuth: true})
+			if err != nil {
+				t.Fatalf("NewClient() error = %v", err)
+			}
+
+			repo := NewRepository(client, BulkConfig{Workers: 1, IngestMode: true},
+				WithMaxRetries(0),
+				WithMaxFailureRatio(0),
+				WithDeadLetterPath(filepath.Join(t.TempDir(), "dead_letter.jsonl")),
+			)
+			repo.taskPollInterval = time.Millisecond
+
+			_, err = repo.BulkIndexTitles(context.Background(), docs)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("BulkIndexTitles() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if strings.Join(requests, "\n") != strings.Join(tt.expected, "\n") {
+				t.Errorf("requests =\n%s\nwant\n%s", strings.Join(requests, "\n"), strings.Join(tt.expected, "\n"))
+			}
+		})
+	}
+}
+
+func TestBulkIndexTitlesCreatedAndUpdated(t *testing.T) {
+	server := newBulkServer(t, nil)
+	defer server.Close()
+
+	client, err := NewClient(Config{URL: server.URL, NoAuth: true})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	repo := NewRepository(client, BulkConfig{},
+		WithDeadLetterPath(filepath.Join(t.TempDir(), "dead_letter.jsonl")),
+	)
+
+	ctx := context.Background()
+	if _, err := repo.BulkIndexTitles(ctx, []TitleDocument{{ID: "tt1"}, {ID: "tt2"}}); err != nil {
+		t.Fatalf("BulkIndexTitles() error = %v", err)
+	}
+	result, err := repo.BulkIndexTitles(ctx, []TitleDocument{{ID: "tt1"}, {ID: "tt2"}, {ID: "tt3"}})
+	if err != nil {
+		t.Fatalf("BulkIndexTitles() error = %v", err)
+	}
+	if result.Indexed != 3 || result.Created != 1 || result.Updated != 2 {
+		t.Errorf("BulkIndexTitles() = %+v, want 1 created and 2 updated", result)
+	}
+}
+
+func TestBulkIndexTitlesSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(noop.NewTracerProvider()) })
+
+	server := newBulkServer(t, map[string][]int{"tt2": {http.StatusTooManyRequests}})
+	defer server.Close()
+
+	client, err := NewClient(Config{URL: server.URL, NoAuth: true})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	repo := NewRepository(client, BulkConfig{},
+		WithMaxRetries(1),
+		WithDeadLetterPath(filepath.Join(t.TempDir(), "dead_letter.jsonl")),
+	)
+
+	docs := []TitleDocument{
+		{ID: "tt1", Body: TitleDocumentBody{Title: "One"}},
+		{ID: "tt2", Body: TitleDocumentBody{Title: "Two"}},
+	}
+	if _, err := repo.BulkIndexTitles(context.Background(), docs); err != nil {
+		t.Fatalf("BulkIndexTitles() error = %v", err)
+	}
+
+	attributes := map[string][]map[attribute.Key]attribute.Value{}
+	for _, span := range recorder.Ended() {
+		values := map[attribute.Key]attribute.Value{}
+		for _, kv := range span.Attributes() {
+			values[kv.Key] = kv.Value
+		}
+		attributes[span.Name()] = append(attributes[span.Name()], values)
+	}
+
+	root := attributes["elasticsearch.BulkIndexTitles"]
+	if len(root) != 1 || root[0]["elasticsearch.documents"].AsInt64() != 2 || root[0]["elasticsearch.failed"].AsInt64() != 0 {
+		t.Errorf("BulkIndexTitles spans = %v, want one span with 2 documents and none failed", root)
+	}
+
+	attempts := attributes["elasticsearch.bulkIndex"]
+	if len(attempts) != 2 {
+		t.Fatalf("got %d bulkIndex spans, want one per attempt", len(attempts))
+	}
+	if attempts[0]["elasticsearch.failures"].AsInt64() != 1 || attempts[1]["elasticsearch.documents"].AsInt64() != 1 {
+		t.Errorf("bulkIndex spans = %v, want the rejected document retried", attempts)
+	}
+}
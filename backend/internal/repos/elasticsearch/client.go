@@ -1,7 +1,15 @@
 package elasticsearch
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"time"
@@ -13,20 +21,82 @@ type Client struct {
 	*elasticsearch.Client
 }
 
-func NewClient() (*Client, error) {
-	password, err := getElasticsearchPassword()
+type ClientOption func(*elasticsearch.Config)
+
+// WithTransport sets the transport used for requests to Elasticsearch. The
+// Elasticsearch client does its own retries, so it takes a transport rather
+// than an http.Client. It replaces the transport that NewClient sets up for
+// the configured CA certificate or fingerprint.
+func WithTransport(transport http.RoundTripper) ClientOption {
+	return func(cfg *elasticsearch.Config) {
+		if userAgent, ok := cfg.Transport.(*userAgentTransport); ok {
+			userAgent.next = transport
+			return
+		}
+		cfg.Transport = transport
+	}
+}
+
+// WithBaseURL sets the Elasticsearch URL, overriding the configured URL or
+// Cloud ID.
+func WithBaseURL(baseURL string) ClientOption {
+	return func(cfg *elasticsearch.Config) {
+		cfg.CloudID = ""
+		cfg.Addresses = []string{baseURL}
+	}
+}
+
+// WithUserAgent sets the User-Agent header sent with every request.
+func WithUserAgent(userAgent string) ClientOption {
+	return func(cfg *elasticsearch.Config) {
+		// The client adds the headers of cfg.Header to the User-Agent it sets
+		// itself, so the header is replaced on the way out instead.
+		if transport, ok := cfg.Transport.(*userAgentTransport); ok {
+			transport.userAgent = userAgent
+			return
+		}
+		cfg.Transport = &userAgentTransport{next: cfg.Transport, userAgent: userAgent}
+	}
+}
+
+type userAgentTransport struct {
+	next      http.RoundTripper
+	userAgent string
+}
+
+func (t *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", t.userAgent)
+	return t.next.RoundTrip(req)
+}
+
+func NewClient(config Config, opts ...ClientOption) (*Client, error) {
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid elasticsearch config: %w", err)
+	}
+
+	transport, err := newTransport(config)
 	if err != nil {
 		return nil, err
 	}
-
 	cfg := elasticsearch.Config{
-		Addresses: []string{getElasticsearchURL()},
-		Username:  getElasticsearchUsername(),
-		Password:  password,
-		Transport: &http.Transport{
-			ResponseHeaderTimeout: 30 * time.Second,
-		},
-		MaxRetries: 3,
+		CloudID:         config.CloudID,
+		Transport:       transport,
+		MaxRetries:      3,
+		Instrumentation: elasticsearch.NewOpenTelemetryInstrumentation(nil, false),
+	}
+	if config.CloudID == "" {
+		cfg.Addresses = []string{config.url()}
+	}
+	if !config.NoAuth {
+		cfg.APIKey = config.APIKey
+		if config.APIKey == "" {
+			cfg.Username = config.username()
+			cfg.Password = config.Password
+		}
+	}
+	for _, opt := range opts {
+		opt(&cfg)
 	}
 
 	client, err := elasticsearch.NewClient(cfg)
@@ -37,26 +107,55 @@ func NewClient() (*Client, error) {
 	return &Client{Client: client}, nil
 }
 
-func getElasticsearchURL() string {
-	url := os.Getenv("ELASTICSEARCH_URL")
-	if url == "" {
-		url = "http://localhost:9200"
+// newTransport returns the transport for the cluster, which trusts the
+// configured CA certificate or certificate fingerprint. The TLS settings are
+// made here rather than by the Elasticsearch client, which only makes them if
+// the transport is an *http.Transport and so not once it is wrapped.
+func newTransport(config Config) (*http.Transport, error) {
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		ResponseHeaderTimeout: 30 * time.Second,
+	}
+
+	if config.CACertPath != "" {
+		caCert, err := os.ReadFile(config.CACertPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read elasticsearch CA certificate: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("no certificates found in %s", config.CACertPath)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
 	}
-	return url
-}
 
-func getElasticsearchPassword() (string, error) {
-	password := os.Getenv("ELASTICSEARCH_PASSWORD")
-	if password == "" {
-		return "", fmt.Errorf("ELASTICSEARCH_PASSWORD environment variable is required")
+	if config.CertificateFingerprint != "" {
+		fingerprint, err := hex.DecodeString(normalizeFingerprint(config.CertificateFingerprint))
+		if err != nil {
+			return nil, fmt.Errorf("invalid certificate fingerprint: %w", err)
+		}
+		transport.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return dialFingerprint(ctx, network, addr, fingerprint)
+		}
 	}
-	return password, nil
+	return transport, nil
 }
 
-func getElasticsearchUsername() string {
-	username := os.Getenv("ELASTICSEARCH_USERNAME")
-	if username == "" {
-		username = "elastic"
+// dialFingerprint connects to a server whose certificate chain holds a
+// certificate with the given SHA-256 fingerprint, as a self-signed cluster
+// certificate cannot be verified otherwise.
+func dialFingerprint(ctx context.Context, network, addr string, fingerprint []byte) (net.Conn, error) {
+	dialer := &tls.Dialer{Config: &tls.Config{InsecureSkipVerify: true}}
+	conn, err := dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	for _, cert := range conn.(*tls.Conn).ConnectionState().PeerCertificates {
+		digest := sha256.Sum256(cert.Raw)
+		if bytes.Equal(digest[:], fingerprint) {
+			return conn, nil
+		}
 	}
-	return username
+	conn.Close()
+	return nil, errors.New("certificate fingerprint mismatch")
 }
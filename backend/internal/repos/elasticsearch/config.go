@@ -0,0 +1,86 @@
+package elasticsearch
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	defaultURL      = "http://localhost:9200"
+	defaultUsername = "elastic"
+)
+
+// Config describes how to connect and authenticate to Elasticsearch.
+type Config struct {
+	// URL of the cluster. Defaults to http://localhost:9200 unless CloudID is set.
+	URL string `config:"url" env:"ELASTICSEARCH_URL" usage:"URL of the Elasticsearch cluster"`
+	// CloudID of an Elastic Cloud deployment, used instead of URL.
+	CloudID string `config:"cloud_id" env:"ELASTICSEARCH_CLOUD_ID" usage:"Cloud ID of an Elastic Cloud deployment"`
+
+	// Username and Password for basic auth. Username defaults to elastic.
+	Username string `config:"username" env:"ELASTICSEARCH_USERNAME" usage:"Elasticsearch user for basic auth"`
+	Password string `config:"password" env:"ELASTICSEARCH_PASSWORD" secret:"true"`
+	// APIKey is the base64 encoded API key, used instead of basic auth.
+	APIKey string `config:"api_key" env:"ELASTICSEARCH_API_KEY" secret:"true"`
+	// NoAuth connects without credentials, e.g. to a local unsecured cluster.
+	NoAuth bool `config:"no_auth" env:"ELASTICSEARCH_NO_AUTH" usage:"connect to Elasticsearch without credentials"`
+
+	// CACertPath is a PEM file with the CA that signed the cluster certificate.
+	CACertPath string `config:"ca_cert" env:"ELASTICSEARCH_CA_CERT" usage:"PEM file with the CA of the Elasticsearch certificate"`
+	// CertificateFingerprint is the SHA-256 fingerprint of the cluster
+	// certificate, as printed by Elasticsearch on first start. Colons are
+	// allowed.
+	CertificateFingerprint string `config:"ca_fingerprint" env:"ELASTICSEARCH_CA_FINGERPRINT" usage:"SHA-256 fingerprint of the Elasticsearch certificate"`
+}
+
+// Validate checks that exactly one address and one way of authenticating is
+// configured.
+func (c Config) Validate() error {
+	var errs []error
+
+	if c.URL != "" && c.CloudID != "" {
+		errs = append(errs, errors.New("URL and CloudID are mutually exclusive"))
+	}
+
+	auth := 0
+	for _, set := range []bool{c.Password != "", c.APIKey != "", c.NoAuth} {
+		if set {
+			auth++
+		}
+	}
+	switch {
+	case auth == 0:
+		errs = append(errs, errors.New("one of password, API key or no-auth is required"))
+	case auth > 1:
+		errs = append(errs, errors.New("password, API key and no-auth are mutually exclusive"))
+	}
+
+	if c.CertificateFingerprint != "" {
+		fingerprint, err := hex.DecodeString(normalizeFingerprint(c.CertificateFingerprint))
+		if err != nil || len(fingerprint) != 32 {
+			errs = append(errs, fmt.Errorf("certificate fingerprint %q is not a SHA-256 hex digest", c.CertificateFingerprint))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (c Config) url() string {
+	if c.URL == "" {
+		return defaultURL
+	}
+	return c.URL
+}
+
+func (c Config) username() string {
+	if c.Username == "" {
+		return defaultUsername
+	}
+	return c.Username
+}
+
+func normalizeFingerprint(fingerprint string) string {
+	return strings.ToLower(strings.ReplaceAll(fingerprint, ":", ""))
+}
@@ -0,0 +1,220 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+)
+
+// BulkConfig tunes how BulkIndexTitles loads documents.
+type BulkConfig struct {
+	// Workers is the number of bulk requests sent concurrently.
+	Workers int `config:"workers" env:"ELASTICSEARCH_BULK_WORKERS" usage:"number of concurrent bulk requests"`
+	// FlushBytes is the size a bulk request is sent at.
+	FlushBytes int `config:"flush_bytes" env:"ELASTICSEARCH_BULK_FLUSH_BYTES" usage:"size in bytes at which a bulk request is sent"`
+	// FlushInterval is how long documents wait for a bulk request to fill.
+	FlushInterval time.Duration `config:"flush_interval" env:"ELASTICSEARCH_BULK_FLUSH_INTERVAL" usage:"longest time documents wait before a bulk request is sent"`
+	// IngestMode turns off refreshes and replicas while loading, and
+	// force-merges the index afterwards. Searches do not see the new
+	// documents until the load has finished.
+	IngestMode bool `config:"ingest_mode" env:"ELASTICSEARCH_BULK_INGEST_MODE" usage:"disable refreshes and replicas during bulk loads"`
+}
+
+func DefaultBulkConfig() BulkConfig {
+	return BulkConfig{
+		Workers:       10,
+		FlushBytes:    5_000_000,
+		FlushInterval: 30 * time.Second,
+	}
+}
+
+// indexSettings are the settings that ingest mode changes. Nil means the
+// setting is not set on the index, and restoring it resets it to the default.
+type indexSettings struct {
+	RefreshInterval  *string `json:"index.refresh_interval"`
+	NumberOfReplicas *string `json:"index.number_of_replicas"`
+}
+
+// beginIngest turns off refreshes and replicas of an index for a bulk load. It
+// returns the settings to restore when the load is done.
+func (r *Repository) beginIngest(ctx context.Context, indexName string) (indexSettings, error) {
+	previous, err := r.getIndexSettings(ctx, indexName)
+	if err != nil {
+		return indexSettings{}, err
+	}
+
+	// A refresh interval of -1 is left behind by a load that did not finish,
+	// and should not be restored.
+	if previous.RefreshInterval != nil && *previous.RefreshInterval == "-1" {
+		slog.Warn("Index has refreshes disabled, probably by an earlier bulk load, they will be reset to the default", "index", indexName)
+		previous.RefreshInterval = nil
+	}
+
+	disabled, zero := "-1", "0"
+	if err := r.putIndexSettings(ctx, indexName, indexSettings{RefreshInterval: &disabled, NumberOfReplicas: &zero}); err != nil {
+		return indexSettings{}, err
+	}
+
+	slog.Info("Started ingest mode", "index", indexName)
+	return previous, nil
+}
+
+// endIngest force-merges the index if the load succeeded, restores its
+// settings and refreshes it.
+func (r *Repository) endIngest(ctx context.Context, indexName string, previous indexSettings, loaded bool) error {
+	// Merge before replicas are added back, so they copy the merged segments.
+	if loaded {
+		if err := r.forceMerge(ctx, indexName); err != nil {
+			return err
+		}
+	}
+
+	if err := r.putIndexSettings(ctx, indexName, previous); err != nil {
+		return err
+	}
+
+	if err := r.Refresh(ctx, indexName); err != nil {
+		return err
+	}
+
+	slog.Info("Finished ingest mode", "index", indexName)
+	return nil
+}
+
+func (r *Repository) getIndexSettings(ctx context.Context, indexName string) (indexSettings, error) {
+	resp, err := r.client.Indices.GetSettings(
+		r.client.Indices.GetSettings.WithContext(ctx),
+		r.client.Indices.GetSettings.WithIndex(indexName),
+		r.client.Indices.GetSettings.WithName("index.refresh_interval", "index.number_of_replicas"),
+		r.client.Indices.GetSettings.WithFlatSettings(true),
+	)
+	if err != nil {
+		return indexSettings{}, fmt.Errorf("failed to get index settings: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.IsError() {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return indexSettings{}, fmt.Errorf("get index settings failed: %s", string(bodyBytes))
+	}
+
+	var response map[string]struct {
+		Settings indexSettings `json:"settings"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return indexSettings{}, fmt.Errorf("failed to decode index settings: %w", err)
+	}
+
+	return response[indexName].Settings, nil
+}
+
+func (r *Repository) putIndexSettings(ctx context.Context, indexName string, settings indexSettings) error {
+	body, err := json.Marshal(settings)
+	if err != nil {
+		return fmt.Errorf("failed to marshal index settings: %w", err)
+	}
+
+	resp, err := r.client.Indices.PutSettings(
+		bytes.NewReader(body),
+		r.client.Indices.PutSettings.WithContext(ctx),
+		r.client.Indices.PutSettings.WithIndex(indexName),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update index settings: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.IsError() {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("update index settings failed: %s", string(bodyBytes))
+	}
+
+	return nil
+}
+
+// forceMerge merges the index down to one segment. Merging a full load takes
+// longer than a request may, so it runs as a task that is polled.
+func (r *Repository) forceMerge(ctx context.Context, indexName string) error {
+	resp, err := r.client.Indices.Forcemerge(
+		r.client.Indices.Forcemerge.WithContext(ctx),
+		r.client.Indices.Forcemerge.WithIndex(indexName),
+		r.client.Indices.Forcemerge.WithMaxNumSegments(1),
+		r.client.Indices.Forcemerge.WithWaitForCompletion(false),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to force merge index: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.IsError() {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("force merge failed: %s", string(bodyBytes))
+	}
+
+	var response struct {
+		Task string `json:"task"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return fmt.Errorf("failed to decode force merge response: %w", err)
+	}
+
+	slog.Info("Force merging index", "index", indexName, "task", response.Task)
+	start := time.Now()
+	if err := r.waitForTask(ctx, response.Task); err != nil {
+		return fmt.Errorf("failed to force merge index: %w", err)
+	}
+	slog.Info("Force merged index", "index", indexName, "duration", time.Since(start))
+
+	return nil
+}
+
+func (r *Repository) waitForTask(ctx context.Context, taskID string) error {
+	for {
+		resp, err := r.client.Tasks.Get(taskID, r.client.Tasks.Get.WithContext(ctx))
+		if err != nil {
+			return fmt.Errorf("failed to get task %s: %w", taskID, err)
+		}
+
+		completed, err := decodeTask(resp)
+		if err != nil {
+			return err
+		}
+		if completed {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(r.taskPollInterval):
+		}
+	}
+}
+
+func decodeTask(resp *esapi.Response) (bool, error) {
+	defer resp.Body.Close()
+
+	if resp.IsError() {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return false, fmt.Errorf("get task failed: %s", string(bodyBytes))
+	}
+
+	var task struct {
+		Completed bool            `json:"completed"`
+		Error     json.RawMessage `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&task); err != nil {
+		return false, fmt.Errorf("failed to decode task: %w", err)
+	}
+	if len(task.Error) > 0 {
+		return false, fmt.Errorf("task failed: %s", string(task.Error))
+	}
+
+	return task.Completed, nil
+}
@@ -4,17 +4,18 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
-
-	"github.com/elastic/go-elasticsearch/v8/esutil"
-	"github.com/jonwilberg/stream-finder/pkg/logging"
 )
 
+var ErrNotFound = errors.New("document not found")
+
 type TitleDocument struct {
 	ID   string
 	Body TitleDocumentBody
@@ -25,12 +26,48 @@ type TitleDocumentBody struct {
 	Title         string   `json:"title"`
 	OriginalTitle string   `json:"original_title"`
 	IsAdult       bool     `json:"is_adult"`
-	Year          int      `json:"year"`
+	Year          int      `json:"year,omitempty"`
 	Genres        []string `json:"genres"`
+	NetflixGenres []string `json:"netflix_genres,omitempty"`
+	// NetflixIDs are the Netflix videos matched to the title.
+	NetflixIDs []string `json:"netflix_ids,omitempty"`
+	// SyncGeneration identifies the sync run that last indexed the document.
+	SyncGeneration int64 `json:"sync_generation"`
 }
 
 type Repository struct {
-	client *Client
+	client           *Client
+	bulk             BulkConfig
+	maxRetries       int
+	maxFailureRatio  float64
+	deadLetterPath   string
+	taskPollInterval time.Duration
+}
+
+type RepositoryOption func(*Repository)
+
+// WithMaxRetries sets how often documents rejected with a retryable status are
+// indexed again before they count as failed.
+func WithMaxRetries(maxRetries int) RepositoryOption {
+	return func(r *Repository) {
+		r.maxRetries = maxRetries
+	}
+}
+
+// WithMaxFailureRatio sets the share of documents that may fail to index
+// before BulkIndexTitles returns an error.
+func WithMaxFailureRatio(ratio float64) RepositoryOption {
+	return func(r *Repository) {
+		r.maxFailureRatio = ratio
+	}
+}
+
+// WithDeadLetterPath sets the file that documents which failed to index are
+// written to.
+func WithDeadLetterPath(path string) RepositoryOption {
+	return func(r *Repository) {
+		r.deadLetterPath = path
+	}
 }
 
 type SearchResponse struct {
@@ -46,48 +83,32 @@ type SearchResponse struct {
 	} `json:"hits"`
 }
 
-func NewRepository(client *Client) *Repository {
-	return &Repository{
-		client: client,
+// NewRepository creates a repository that bulk loads with the given config.
+// Zero fields of the config take their value from DefaultBulkConfig.
+func NewRepository(client *Client, bulk BulkConfig, opts ...RepositoryOption) *Repository {
+	defaults := DefaultBulkConfig()
+	if bulk.Workers <= 0 {
+		bulk.Workers = defaults.Workers
 	}
-}
-
-func (r *Repository) BulkIndexTitles(ctx context.Context, titleDocs []TitleDocument) error {
-	bulkIndexerConfig := esutil.BulkIndexerConfig{
-		Index:         "titles",
-		Client:        r.client,
-		NumWorkers:    10,
-		FlushBytes:    5_000_000,
-		FlushInterval: 30 * time.Second,
+	if bulk.FlushBytes <= 0 {
+		bulk.FlushBytes = defaults.FlushBytes
 	}
-
-	bi, err := esutil.NewBulkIndexer(bulkIndexerConfig)
-	if err != nil {
-		return fmt.Errorf("failed to create bulk indexer: %w", err)
+	if bulk.FlushInterval <= 0 {
+		bulk.FlushInterval = defaults.FlushInterval
 	}
 
-	bar := logging.NewProgressBar("Indexing titles to Elasticsearch", len(titleDocs))
-
-	for _, doc := range titleDocs {
-		docJSON, err := json.Marshal(doc.Body)
-		if err != nil {
-			return fmt.Errorf("failed to marshal document: %w", err)
-		}
-		bi.Add(ctx, esutil.BulkIndexerItem{
-			Action:     "index",
-			DocumentID: doc.ID,
-			Body:       bytes.NewReader(docJSON),
-		})
-		bar.Add(1)
+	r := &Repository{
+		client:           client,
+		bulk:             bulk,
+		maxRetries:       3,
+		maxFailureRatio:  0.001,
+		deadLetterPath:   filepath.Join(os.TempDir(), "titles_dead_letter.jsonl"),
+		taskPollInterval: 5 * time.Second,
 	}
-
-	bar.Finish()
-
-	if err := bi.Close(ctx); err != nil {
-		return fmt.Errorf("failed to close bulk indexer: %w", err)
+	for _, opt := range opts {
+		opt(r)
 	}
-
-	return nil
+	return r
 }
 
 func (r *Repository) EnsureIndexExists(ctx context.Context, indexName string, mappingJSON string) error {
@@ -97,7 +118,7 @@ func (r *Repository) EnsureIndexExists(ctx context.Context, indexName string, ma
 	}
 
 	if exists.StatusCode == 200 {
-		return nil
+		return r.updateIndexMapping(ctx, indexName, mappingJSON)
 	}
 
 	resp, err := r.client.Indices.Create(
@@ -118,6 +139,37 @@ func (r *Repository) EnsureIndexExists(ctx context.Context, indexName string, ma
 	return nil
 }
 
+// updateIndexMapping applies the mappings of the schema to an existing index,
+// so that fields added to the schema are indexed without recreating it.
+func (r *Repository) updateIndexMapping(ctx context.Context, indexName string, mappingJSON string) error {
+	var schema struct {
+		Mappings json.RawMessage `json:"mappings"`
+	}
+	if err := json.Unmarshal([]byte(mappingJSON), &schema); err != nil {
+		return fmt.Errorf("failed to parse index schema: %w", err)
+	}
+	if len(schema.Mappings) == 0 {
+		return nil
+	}
+
+	resp, err := r.client.Indices.PutMapping(
+		[]string{indexName},
+		bytes.NewReader(schema.Mappings),
+		r.client.Indices.PutMapping.WithContext(ctx),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update index mapping: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.IsError() {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("failed to update index mapping: %s", string(bodyBytes))
+	}
+
+	return nil
+}
+
 func (r *Repository) UpdateIndices(ctx context.Context) error {
 	schemasDir := "internal/repos/elasticsearch/schemas"
 
@@ -175,3 +227,118 @@ func (r *Repository) Search(ctx context.Context, indexName string, query map[str
 
 	return bodyBytes, nil
 }
+
+// GetTitle returns the title document with the given ID, or an error wrapping
+// ErrNotFound.
+func (r *Repository) GetTitle(ctx context.Context, id string) (*TitleDocument, error) {
+	resp, err := r.client.Get("titles", id, r.client.Get.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get title: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("title %s: %w", id, ErrNotFound)
+	}
+	if resp.IsError() {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("get title failed: %s", string(bodyBytes))
+	}
+
+	var response struct {
+		ID     string            `json:"_id"`
+		Source TitleDocumentBody `json:"_source"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to decode title: %w", err)
+	}
+
+	return &TitleDocument{ID: response.ID, Body: response.Source}, nil
+}
+
+func (r *Repository) Refresh(ctx context.Context, indexName string) error {
+	resp, err := r.client.Indices.Refresh(
+		r.client.Indices.Refresh.WithContext(ctx),
+		r.client.Indices.Refresh.WithIndex(indexName),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to refresh index: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.IsError() {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("refresh failed: %s", string(bodyBytes))
+	}
+
+	return nil
+}
+
+func (r *Repository) Count(ctx context.Context, indexName string, query map[string]any) (int, error) {
+	queryJSON, err := json.Marshal(query)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal query: %w", err)
+	}
+
+	resp, err := r.client.Count(
+		r.client.Count.WithContext(ctx),
+		r.client.Count.WithIndex(indexName),
+		r.client.Count.WithBody(bytes.NewReader(queryJSON)),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to execute count: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.IsError() {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return 0, fmt.Errorf("count failed: %s", string(bodyBytes))
+	}
+
+	var response struct {
+		Count int `json:"count"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return 0, fmt.Errorf("failed to decode count response: %w", err)
+	}
+
+	return response.Count, nil
+}
+
+func (r *Repository) DeleteByQuery(ctx context.Context, indexName string, query map[string]any) (int, error) {
+	queryJSON, err := json.Marshal(query)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal query: %w", err)
+	}
+
+	resp, err := r.client.DeleteByQuery(
+		[]string{indexName},
+		bytes.NewReader(queryJSON),
+		r.client.DeleteByQuery.WithContext(ctx),
+		r.client.DeleteByQuery.WithConflicts("proceed"),
+		r.client.DeleteByQuery.WithRefresh(true),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to execute delete by query: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.IsError() {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return 0, fmt.Errorf("delete by query failed: %s", string(bodyBytes))
+	}
+
+	var response struct {
+		Deleted  int               `json:"deleted"`
+		Failures []json.RawMessage `json:"failures"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return 0, fmt.Errorf("failed to decode delete by query response: %w", err)
+	}
+
+	if len(response.Failures) > 0 {
+		return response.Deleted, fmt.Errorf("delete by query had %d failures, first: %s", len(response.Failures), string(response.Failures[0]))
+	}
+
+	return response.Deleted, nil
+}
@@ -0,0 +1,110 @@
+package firestore
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"cloud.google.com/go/firestore"
+	"github.com/jonwilberg/stream-finder/internal/availability"
+)
+
+// AvailabilityStore keeps the titles of a provider in the <provider>_titles
+// collection and their history in <provider>_title_history.
+type AvailabilityStore struct {
+	client  *firestore.Client
+	titles  *Collection[availability.Title]
+	history *Collection[availability.Event]
+}
+
+func NewAvailabilityStore(client *firestore.Client, provider string) *AvailabilityStore {
+	return &AvailabilityStore{
+		client:  client,
+		titles:  NewCollection[availability.Title](client, provider+"_titles"),
+		history: NewCollection[availability.Event](client, provider+"_title_history"),
+	}
+}
+
+// Titles gives typed access to the titles collection of the store.
+func (s *AvailabilityStore) Titles() *Collection[availability.Title] {
+	return s.titles
+}
+
+func (s *AvailabilityStore) ReadIDs(ctx context.Context) ([]string, error) {
+	return s.titles.IDs(ctx)
+}
+
+func (s *AvailabilityStore) GetMany(ctx context.Context, ids []string) ([]availability.Record, error) {
+	titles, err := s.titles.GetMany(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []availability.Record
+	for _, id := range ids {
+		if title, ok := titles[id]; ok {
+			records = append(records, availability.Record{ID: id, Title: *title})
+		}
+	}
+	return records, nil
+}
+
+func (s *AvailabilityStore) Upsert(ctx context.Context, records []availability.Record) error {
+	entries := make([]Entry[availability.Title], 0, len(records))
+	for _, record := range records {
+		entries = append(entries, Entry[availability.Title]{
+			ID:    record.ID,
+			Value: record.Title,
+		})
+	}
+
+	result, err := s.titles.Put(ctx, entries, WriteSet)
+	if err != nil {
+		return fmt.Errorf("failed to upsert %d of %d titles: %w", result.Failed, len(records), err)
+	}
+	return nil
+}
+
+func (s *AvailabilityStore) Delete(ctx context.Context, ids []string) error {
+	return s.titles.Delete(ctx, ids)
+}
+
+func (s *AvailabilityStore) AppendHistory(ctx context.Context, events []availability.Event) error {
+	collection := s.client.Collection(s.history.Name())
+	documents := make([]Document, 0, len(events))
+	for _, event := range events {
+		documents = append(documents, Document{
+			ID:   collection.NewDoc().ID,
+			Data: event,
+		})
+	}
+
+	if _, err := BulkWrite(ctx, s.client, s.history.Name(), documents, WriteCreate); err != nil {
+		return fmt.Errorf("failed to append history: %w", err)
+	}
+	return nil
+}
+
+func (s *AvailabilityStore) History(ctx context.Context, titleID string) ([]availability.Event, error) {
+	// Ordering in the query would need a composite index, so the events of the
+	// title are sorted here instead.
+	entries, err := s.history.Query(ctx, func(q firestore.Query) firestore.Query {
+		return q.Where("title_id", "==", titleID)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]availability.Event, 0, len(entries))
+	for _, entry := range entries {
+		events = append(events, entry.Value)
+	}
+	slices.SortStableFunc(events, func(a, b availability.Event) int {
+		return a.At.Compare(b.At)
+	})
+	return events, nil
+}
+
+func (s *AvailabilityStore) Close() error {
+	return s.client.Close()
+}
@@ -3,16 +3,62 @@ package firestore
 import (
 	"context"
 	"fmt"
-	"os"
+	"log/slog"
 
 	"cloud.google.com/go/firestore"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
 )
 
-func NewFirestoreClient(ctx context.Context) (*firestore.Client, error) {
-	projectID := os.Getenv("GC_PROJECT_ID")
-	client, err := firestore.NewClient(ctx, projectID)
+// EmulatorProjectID is used when running against the Firestore emulator
+// without a project ID. The demo- prefix keeps the emulator from reaching out
+// to real Google Cloud services.
+const EmulatorProjectID = "demo-stream-finder"
+
+type Config struct {
+	ProjectID string `config:"project_id" env:"GC_PROJECT_ID" usage:"Google Cloud project of the Firestore database"`
+	// EmulatorHost is the host:port of a Firestore emulator to use instead of
+	// Google Cloud.
+	EmulatorHost string `config:"emulator_host" env:"FIRESTORE_EMULATOR_HOST" usage:"host:port of a Firestore emulator"`
+}
+
+// NewFirestoreClient connects to the configured project, or to the Firestore
+// emulator if an emulator host is set.
+func NewFirestoreClient(ctx context.Context, config Config) (*firestore.Client, error) {
+	projectID := config.ProjectID
+	var opts []option.ClientOption
+	if config.EmulatorHost != "" {
+		if projectID == "" {
+			projectID = EmulatorProjectID
+		}
+		// The emulator is set per client rather than through
+		// FIRESTORE_EMULATOR_HOST, which would send every client in the
+		// process to it.
+		opts = append(opts,
+			option.WithEndpoint(config.EmulatorHost),
+			option.WithoutAuthentication(),
+			option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())),
+			option.WithGRPCDialOption(grpc.WithPerRPCCredentials(emulatorCredentials{})),
+		)
+		slog.Info("Using Firestore emulator", "host", config.EmulatorHost, "project", projectID)
+	}
+	client, err := firestore.NewClient(ctx, projectID, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create firestore client: %w", err)
 	}
 	return client, nil
 }
+
+// emulatorCredentials authenticate as the owner, which the emulator lets
+// past its security rules, as the Firestore client does when it reads the
+// emulator host from the environment.
+type emulatorCredentials struct{}
+
+func (emulatorCredentials) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer owner"}, nil
+}
+
+func (emulatorCredentials) RequireTransportSecurity() bool {
+	return false
+}
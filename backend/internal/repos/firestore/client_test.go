@@ -0,0 +1,23 @@
+package firestore_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	firestore_repo "github.com/jonwilberg/stream-finder/internal/repos/firestore"
+)
+
+func TestNewFirestoreClientKeepsEnvironment(t *testing.T) {
+	t.Setenv("FIRESTORE_EMULATOR_HOST", "")
+
+	client, err := firestore_repo.NewFirestoreClient(context.Background(), firestore_repo.Config{EmulatorHost: "localhost:1"})
+	if err != nil {
+		t.Fatalf("NewFirestoreClient() error = %v", err)
+	}
+	defer client.Close()
+
+	if host := os.Getenv("FIRESTORE_EMULATOR_HOST"); host != "" {
+		t.Errorf("FIRESTORE_EMULATOR_HOST = %q, want the environment left as is", host)
+	}
+}
@@ -0,0 +1,140 @@
+package firestore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var ErrNotFound = errors.New("document not found")
+
+// Entry is a document of a Collection together with its ID.
+type Entry[T any] struct {
+	ID    string
+	Value T
+}
+
+// Collection gives typed access to a Firestore collection whose documents
+// decode into T.
+type Collection[T any] struct {
+	client *firestore.Client
+	name   string
+}
+
+func NewCollection[T any](client *firestore.Client, name string) *Collection[T] {
+	return &Collection[T]{
+		client: client,
+		name:   name,
+	}
+}
+
+func (c *Collection[T]) Name() string {
+	return c.name
+}
+
+// Get returns the document with the given ID, or ErrNotFound.
+func (c *Collection[T]) Get(ctx context.Context, id string) (*T, error) {
+	doc, err := c.client.Collection(c.name).Doc(id).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, fmt.Errorf("%s/%s: %w", c.name, id, ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get document %s: %w", id, err)
+	}
+
+	var value T
+	if err := doc.DataTo(&value); err != nil {
+		return nil, fmt.Errorf("failed to decode document %s: %w", id, err)
+	}
+	return &value, nil
+}
+
+// GetMany returns the documents with the given IDs. IDs without a document are
+// left out of the result.
+func (c *Collection[T]) GetMany(ctx context.Context, ids []string) (map[string]*T, error) {
+	collection := c.client.Collection(c.name)
+	values := make(map[string]*T, len(ids))
+
+	for start := 0; start < len(ids); start += defaultPageSize {
+		end := min(start+defaultPageSize, len(ids))
+
+		refs := make([]*firestore.DocumentRef, 0, end-start)
+		for _, id := range ids[start:end] {
+			refs = append(refs, collection.Doc(id))
+		}
+
+		docs, err := c.client.GetAll(ctx, refs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get documents: %w", err)
+		}
+
+		for _, doc := range docs {
+			if !doc.Exists() {
+				continue
+			}
+			var value T
+			if err := doc.DataTo(&value); err != nil {
+				return nil, fmt.Errorf("failed to decode document %s: %w", doc.Ref.ID, err)
+			}
+			values[doc.Ref.ID] = &value
+		}
+	}
+
+	return values, nil
+}
+
+// Put writes the entries in the given mode, see BulkWrite. WriteMerge merges
+// the fields of T, leaving out empty omitempty ones. WriteUpdate needs
+// field paths and cannot be used with a typed value.
+func (c *Collection[T]) Put(ctx context.Context, entries []Entry[T], mode WriteMode) (WriteResult, error) {
+	if mode == WriteUpdate {
+		return WriteResult{}, fmt.Errorf("collection %s: WriteUpdate is not supported for typed entries", c.name)
+	}
+
+	documents := make([]Document, 0, len(entries))
+	for _, entry := range entries {
+		documents = append(documents, Document{
+			ID:   entry.ID,
+			Data: entry.Value,
+		})
+	}
+	return BulkWrite(ctx, c.client, c.name, documents, mode)
+}
+
+func (c *Collection[T]) Delete(ctx context.Context, ids []string) error {
+	return BulkDelete(ctx, c.client, c.name, ids)
+}
+
+// Query returns the documents matched by the query that build makes from the
+// collection, e.g. a Where or OrderBy clause.
+func (c *Collection[T]) Query(ctx context.Context, build func(firestore.Query) firestore.Query) ([]Entry[T], error) {
+	query := build(c.client.Collection(c.name).Query)
+	docs, err := query.Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to query %s: %w", c.name, err)
+	}
+
+	entries := make([]Entry[T], 0, len(docs))
+	for _, doc := range docs {
+		var value T
+		if err := doc.DataTo(&value); err != nil {
+			return nil, fmt.Errorf("failed to decode document %s: %w", doc.Ref.ID, err)
+		}
+		entries = append(entries, Entry[T]{ID: doc.Ref.ID, Value: value})
+	}
+	return entries, nil
+}
+
+// Iterate calls fn for every document in the collection, reading it in pages.
+func (c *Collection[T]) Iterate(ctx context.Context, fn func(id string, value *T) error) error {
+	return ReadInto(ctx, c.client, c.name, fn)
+}
+
+// IDs returns the IDs of all documents without reading their data.
+func (c *Collection[T]) IDs(ctx context.Context) ([]string, error) {
+	return ReadIDs(ctx, c.client, c.name)
+}
@@ -0,0 +1,60 @@
+// Package firestoretest connects tests to the Firestore emulator.
+package firestoretest
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	firestore_repo "github.com/jonwilberg/stream-finder/internal/repos/firestore"
+)
+
+// NewClient returns a client for a project of its own in the Firestore
+// emulator, so tests do not see each other's documents. The test is skipped
+// unless FIRESTORE_EMULATOR_HOST is set.
+func NewClient(t *testing.T) *firestore.Client {
+	t.Helper()
+
+	emulatorHost := os.Getenv("FIRESTORE_EMULATOR_HOST")
+	if emulatorHost == "" {
+		t.Skip("FIRESTORE_EMULATOR_HOST is not set")
+	}
+
+	name := strings.ToLower(strings.NewReplacer("/", "-", "_", "-", " ", "-").Replace(t.Name()))
+	client, err := firestore_repo.NewFirestoreClient(context.Background(), firestore_repo.Config{
+		ProjectID:    fmt.Sprintf("demo-%s-%d", name, time.Now().UnixNano()),
+		EmulatorHost: emulatorHost,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create firestore client: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	return client
+}
+
+// Seed writes documents to a collection.
+func Seed(t *testing.T, client *firestore.Client, collectionName string, documents map[string]any) {
+	t.Helper()
+
+	batch := client.BulkWriter(context.Background())
+	jobs := make([]*firestore.BulkWriterJob, 0, len(documents))
+	for id, data := range documents {
+		job, err := batch.Set(client.Collection(collectionName).Doc(id), data)
+		if err != nil {
+			t.Fatalf("Failed to seed document %s: %v", id, err)
+		}
+		jobs = append(jobs, job)
+	}
+	batch.End()
+
+	for _, job := range jobs {
+		if _, err := job.Results(); err != nil {
+			t.Fatalf("Failed to seed documents: %v", err)
+		}
+	}
+}
@@ -3,73 +3,343 @@ package firestore
 import (
 	"context"
 	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
 
 	"cloud.google.com/go/firestore"
-	"google.golang.org/api/iterator"
+	"github.com/jonwilberg/stream-finder/internal/telemetry"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	tracer = otel.Tracer("github.com/jonwilberg/stream-finder/internal/repos/firestore")
+	meter  = otel.Meter("github.com/jonwilberg/stream-finder/internal/repos/firestore")
+
+	bulkDocuments, _ = meter.Int64Counter("firestore.bulk.documents",
+		metric.WithDescription("Documents sent in Firestore bulk operations, by operation and outcome"),
+	)
 )
 
 type Document struct {
 	ID   string
 	Data any
+	// UpdateTime, if set, is a precondition for WriteUpdate: the write fails
+	// if the document was changed after this time.
+	UpdateTime time.Time
+}
+
+type WriteMode int
+
+const (
+	// WriteSet creates documents or overwrites them.
+	WriteSet WriteMode = iota
+	// WriteMerge creates documents or merges their fields into existing ones.
+	// Data is a map or a struct; empty omitempty fields of a struct are kept.
+	WriteMerge
+	// WriteCreate creates documents and fails for those that already exist.
+	WriteCreate
+	// WriteUpdate updates fields of existing documents and fails for those
+	// that do not exist. Data must be a map[string]any of field paths.
+	WriteUpdate
+)
+
+type WriteResult struct {
+	Written int
+	Failed  int
+}
+
+type DocumentError struct {
+	ID  string
+	Err error
 }
 
-func BulkWrite(ctx context.Context, client *firestore.Client, collectionName string, documents []Document) error {
+func (e *DocumentError) Error() string {
+	return fmt.Sprintf("document %s: %v", e.ID, e.Err)
+}
+
+func (e *DocumentError) Unwrap() error {
+	return e.Err
+}
+
+// BulkWriteError holds the documents that failed in a bulk operation.
+type BulkWriteError struct {
+	Failures []*DocumentError
+}
+
+func (e *BulkWriteError) Error() string {
+	const maxListed = 5
+	messages := make([]string, 0, maxListed)
+	for _, failure := range e.Failures[:min(len(e.Failures), maxListed)] {
+		messages = append(messages, failure.Error())
+	}
+	return fmt.Sprintf("%d documents failed: %s", len(e.Failures), strings.Join(messages, "; "))
+}
+
+// BulkWrite writes the documents in the given mode. All documents are
+// attempted; the failures are returned as a *BulkWriteError.
+func BulkWrite(ctx context.Context, client *firestore.Client, collectionName string, documents []Document, mode WriteMode) (result WriteResult, err error) {
+	ctx, span := tracer.Start(ctx, "firestore.BulkWrite", trace.WithAttributes(
+		attribute.String("firestore.collection", collectionName),
+		attribute.Int("firestore.mode", int(mode)),
+		attribute.Int("firestore.documents", len(documents)),
+	))
+	defer func() { telemetry.End(span, err) }()
+
 	collection := client.Collection(collectionName)
 	bulkWriter := client.BulkWriter(ctx)
 
+	ids := make([]string, 0, len(documents))
+	jobs := make([]*firestore.BulkWriterJob, 0, len(documents))
+	var failures []*DocumentError
+
 	for _, doc := range documents {
-		_, err := bulkWriter.Set(collection.Doc(doc.ID), doc.Data)
+		job, err := enqueueWrite(bulkWriter, collection.Doc(doc.ID), doc, mode)
 		if err != nil {
-			return fmt.Errorf("failed to create bulk writer job: %w", err)
+			failures = append(failures, &DocumentError{ID: doc.ID, Err: err})
+			continue
 		}
+		ids = append(ids, doc.ID)
+		jobs = append(jobs, job)
 	}
 
 	bulkWriter.End()
-	return nil
+	failures = append(failures, collectJobFailures(ids, jobs)...)
+
+	result = WriteResult{
+		Written: len(documents) - len(failures),
+		Failed:  len(failures),
+	}
+	recordBulkDocuments(ctx, span, "write", result.Written, result.Failed)
+	if len(failures) > 0 {
+		return result, &BulkWriteError{Failures: failures}
+	}
+	return result, nil
+}
+
+func enqueueWrite(bulkWriter *firestore.BulkWriter, ref *firestore.DocumentRef, doc Document, mode WriteMode) (*firestore.BulkWriterJob, error) {
+	switch mode {
+	case WriteSet:
+		return bulkWriter.Set(ref, doc.Data)
+	case WriteMerge:
+		// MergeAll only works with maps, so the fields of a struct are merged
+		// by path instead.
+		if reflect.Indirect(reflect.ValueOf(doc.Data)).Kind() == reflect.Map {
+			return bulkWriter.Set(ref, doc.Data, firestore.MergeAll)
+		}
+		paths, err := structFieldPaths(doc.Data)
+		if err != nil {
+			return nil, err
+		}
+		return bulkWriter.Set(ref, doc.Data, firestore.Merge(paths...))
+	case WriteCreate:
+		return bulkWriter.Create(ref, doc.Data)
+	case WriteUpdate:
+		fields, ok := doc.Data.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("update data must be map[string]any, got %T", doc.Data)
+		}
+		updates := make([]firestore.Update, 0, len(fields))
+		for path, value := range fields {
+			updates = append(updates, firestore.Update{Path: path, Value: value})
+		}
+		var preconditions []firestore.Precondition
+		if !doc.UpdateTime.IsZero() {
+			preconditions = append(preconditions, firestore.LastUpdateTime(doc.UpdateTime))
+		}
+		return bulkWriter.Update(ref, updates, preconditions...)
+	default:
+		return nil, fmt.Errorf("unknown write mode %d", mode)
+	}
+}
+
+// structFieldPaths returns the paths of the fields that Firestore writes for a
+// struct, named by their firestore tags. Fields tagged omitempty are left out
+// when they are empty, as they would be by a Set without merge.
+func structFieldPaths(data any) ([]firestore.FieldPath, error) {
+	v := reflect.Indirect(reflect.ValueOf(data))
+	if v.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("merge data must be a map or struct, got %T", data)
+	}
+	paths := appendFieldPaths(nil, v)
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("merge data %T has no fields to write", data)
+	}
+	return paths, nil
 }
 
-func BulkDelete(ctx context.Context, client *firestore.Client, collectionName string, documentIDs []string) error {
+func appendFieldPaths(paths []firestore.FieldPath, v reflect.Value) []firestore.FieldPath {
+	for i := range v.NumField() {
+		field := v.Type().Field(i)
+		name, options, _ := strings.Cut(field.Tag.Get("firestore"), ",")
+		if name == "-" {
+			continue
+		}
+		value := v.Field(i)
+		// The fields of an embedded struct are written as if they were
+		// fields of the outer one.
+		if field.Anonymous && name == "" {
+			if embedded := reflect.Indirect(value); embedded.Kind() == reflect.Struct {
+				paths = appendFieldPaths(paths, embedded)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if slices.Contains(strings.Split(options, ","), "omitempty") && value.IsZero() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		paths = append(paths, firestore.FieldPath{name})
+	}
+	return paths
+}
+
+func collectJobFailures(ids []string, jobs []*firestore.BulkWriterJob) []*DocumentError {
+	var failures []*DocumentError
+	for i, job := range jobs {
+		if _, err := job.Results(); err != nil {
+			failures = append(failures, &DocumentError{ID: ids[i], Err: err})
+		}
+	}
+	return failures
+}
+
+func BulkDelete(ctx context.Context, client *firestore.Client, collectionName string, documentIDs []string) (err error) {
+	ctx, span := tracer.Start(ctx, "firestore.BulkDelete", trace.WithAttributes(
+		attribute.String("firestore.collection", collectionName),
+		attribute.Int("firestore.documents", len(documentIDs)),
+	))
+	defer func() { telemetry.End(span, err) }()
+
 	collection := client.Collection(collectionName)
 	bulkWriter := client.BulkWriter(ctx)
 
+	ids := make([]string, 0, len(documentIDs))
 	jobs := make([]*firestore.BulkWriterJob, 0, len(documentIDs))
+	var failures []*DocumentError
+
 	for _, id := range documentIDs {
 		job, err := bulkWriter.Delete(collection.Doc(id))
 		if err != nil {
-			return fmt.Errorf("failed to create delete job: %w", err)
+			failures = append(failures, &DocumentError{ID: id, Err: err})
+			continue
 		}
+		ids = append(ids, id)
 		jobs = append(jobs, job)
 	}
 
 	bulkWriter.End()
-	for _, job := range jobs {
-		if _, err := job.Results(); err != nil {
-			return fmt.Errorf("firestore delete job failed: %w", err)
-		}
+	failures = append(failures, collectJobFailures(ids, jobs)...)
+
+	recordBulkDocuments(ctx, span, "delete", len(documentIDs)-len(failures), len(failures))
+	if len(failures) > 0 {
+		return &BulkWriteError{Failures: failures}
 	}
 	return nil
 }
 
-func ReadAll(ctx context.Context, client *firestore.Client, collectionName string) ([]Document, error) {
-	collection := client.Collection(collectionName)
-	iter := collection.Documents(ctx)
-	defer iter.Stop()
+func recordBulkDocuments(ctx context.Context, span trace.Span, op string, written, failed int) {
+	span.SetAttributes(
+		attribute.Int("firestore.written", written),
+		attribute.Int("firestore.failed", failed),
+	)
+	bulkDocuments.Add(ctx, int64(written), metric.WithAttributes(
+		attribute.String("op", op), attribute.String("outcome", "written"),
+	))
+	bulkDocuments.Add(ctx, int64(failed), metric.WithAttributes(
+		attribute.String("op", op), attribute.String("outcome", "failed"),
+	))
+}
+
+const defaultPageSize = 500
+
+// ReadPages reads a collection in pages of up to pageSize documents ordered by
+// document ID, so that only one page is held in memory at a time. pageSize
+// must be at least 1.
+func ReadPages(ctx context.Context, client *firestore.Client, collectionName string, pageSize int, fn func(page []*firestore.DocumentSnapshot) error) error {
+	if pageSize < 1 {
+		return fmt.Errorf("page size must be at least 1, got %d", pageSize)
+	}
+	return readPages(ctx, client.Collection(collectionName).Query, pageSize, fn)
+}
 
-	var documents []Document
+// ReadInto reads a collection page by page, decoding each document into a T.
+func ReadInto[T any](ctx context.Context, client *firestore.Client, collectionName string, fn func(id string, value *T) error) error {
+	return ReadPages(ctx, client, collectionName, defaultPageSize, func(page []*firestore.DocumentSnapshot) error {
+		for _, doc := range page {
+			var value T
+			if err := doc.DataTo(&value); err != nil {
+				return fmt.Errorf("failed to decode document %s: %w", doc.Ref.ID, err)
+			}
+			if err := fn(doc.Ref.ID, &value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ReadIDs returns the IDs of all documents in a collection. No fields are
+// selected, so no document data is transferred.
+func ReadIDs(ctx context.Context, client *firestore.Client, collectionName string) ([]string, error) {
+	var ids []string
+	query := client.Collection(collectionName).Select()
+	err := readPages(ctx, query, defaultPageSize, func(page []*firestore.DocumentSnapshot) error {
+		for _, doc := range page {
+			ids = append(ids, doc.Ref.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+func readPages(ctx context.Context, query firestore.Query, pageSize int, fn func(page []*firestore.DocumentSnapshot) error) (err error) {
+	ctx, span := tracer.Start(ctx, "firestore.readPages")
+	pages, documents := 0, 0
+	defer func() {
+		span.SetAttributes(
+			attribute.Int("firestore.pages", pages),
+			attribute.Int("firestore.documents", documents),
+		)
+		telemetry.End(span, err)
+	}()
+
+	query = query.OrderBy(firestore.DocumentID, firestore.Asc).Limit(pageSize)
+
+	var lastID string
 	for {
-		doc, err := iter.Next()
-		if err == iterator.Done {
-			break
+		pageQuery := query
+		if lastID != "" {
+			pageQuery = query.StartAfter(lastID)
 		}
+
+		page, err := pageQuery.Documents(ctx).GetAll()
 		if err != nil {
-			return nil, fmt.Errorf("failed to read document: %w", err)
+			return fmt.Errorf("failed to read documents: %w", err)
 		}
+		pages++
+		documents += len(page)
 
-		documents = append(documents, Document{
-			ID:   doc.Ref.ID,
-			Data: doc.Data(),
-		})
-	}
+		if len(page) > 0 {
+			if err := fn(page); err != nil {
+				return err
+			}
+		}
 
-	return documents, nil
+		if len(page) < pageSize {
+			return nil
+		}
+		lastID = page[len(page)-1].Ref.ID
+	}
 }
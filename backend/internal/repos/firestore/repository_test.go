@@ -0,0 +1,226 @@
+package firestore_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	firestore_repo "github.com/jonwilberg/stream-finder/internal/repos/firestore"
+	"github.com/jonwilberg/stream-finder/internal/repos/firestore/firestoretest"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type item struct {
+	N    int    `firestore:"n"`
+	Keep string `firestore:"keep,omitempty"`
+}
+
+func seedItems(t *testing.T, client *firestore.Client, collectionName string, count int) []string {
+	t.Helper()
+
+	ids := make([]string, 0, count)
+	documents := make(map[string]any, count)
+	for i := 0; i < count; i++ {
+		id := fmt.Sprintf("item-%05d", i)
+		ids = append(ids, id)
+		documents[id] = item{N: i}
+	}
+	firestoretest.Seed(t, client, collectionName, documents)
+	return ids
+}
+
+func TestBulkWrite(t *testing.T) {
+	ctx := context.Background()
+	client := firestoretest.NewClient(t)
+	firestoretest.Seed(t, client, "items", map[string]any{
+		"a": item{N: 1, Keep: "x"},
+	})
+	collection := firestore_repo.NewCollection[item](client, "items")
+
+	result, err := firestore_repo.BulkWrite(ctx, client, "items", []firestore_repo.Document{
+		{ID: "a", Data: item{N: 10}},
+		{ID: "b", Data: item{N: 2}},
+	}, firestore_repo.WriteCreate)
+	var bulkErr *firestore_repo.BulkWriteError
+	if !errors.As(err, &bulkErr) || len(bulkErr.Failures) != 1 || bulkErr.Failures[0].ID != "a" {
+		t.Fatalf("BulkWrite(WriteCreate) error = %v, want failure for a", err)
+	}
+	if status.Code(bulkErr.Failures[0].Err) != codes.AlreadyExists {
+		t.Errorf("BulkWrite(WriteCreate) failure = %v, want AlreadyExists", bulkErr.Failures[0].Err)
+	}
+	if result.Written != 1 || result.Failed != 1 {
+		t.Errorf("BulkWrite(WriteCreate) result = %+v, want 1 written and 1 failed", result)
+	}
+
+	if _, err := firestore_repo.BulkWrite(ctx, client, "items", []firestore_repo.Document{
+		{ID: "a", Data: map[string]any{"n": 2}},
+	}, firestore_repo.WriteMerge); err != nil {
+		t.Fatalf("BulkWrite(WriteMerge) error = %v", err)
+	}
+	if got, _ := collection.Get(ctx, "a"); got == nil || *got != (item{N: 2, Keep: "x"}) {
+		t.Errorf("after WriteMerge a = %+v, want merged fields", got)
+	}
+
+	if _, err := firestore_repo.BulkWrite(ctx, client, "items", []firestore_repo.Document{
+		{ID: "a", Data: item{N: 7}},
+		{ID: "b", Data: &item{N: 8, Keep: "y"}},
+	}, firestore_repo.WriteMerge); err != nil {
+		t.Fatalf("BulkWrite(WriteMerge) with structs error = %v", err)
+	}
+	if got, _ := collection.Get(ctx, "a"); got == nil || *got != (item{N: 7, Keep: "x"}) {
+		t.Errorf("after struct WriteMerge a = %+v, want n merged and keep left as is", got)
+	}
+	if got, _ := collection.Get(ctx, "b"); got == nil || *got != (item{N: 8, Keep: "y"}) {
+		t.Errorf("after struct WriteMerge b = %+v, want both fields written", got)
+	}
+
+	if _, err := firestore_repo.BulkWrite(ctx, client, "items", []firestore_repo.Document{
+		{ID: "a", Data: item{N: 3}},
+	}, firestore_repo.WriteSet); err != nil {
+		t.Fatalf("BulkWrite(WriteSet) error = %v", err)
+	}
+	if got, _ := collection.Get(ctx, "a"); got == nil || *got != (item{N: 3}) {
+		t.Errorf("after WriteSet a = %+v, want overwritten document", got)
+	}
+
+	result, err = firestore_repo.BulkWrite(ctx, client, "items", []firestore_repo.Document{
+		{ID: "a", Data: map[string]any{"n": 4}, UpdateTime: time.Unix(1, 0)},
+		{ID: "b", Data: map[string]any{"n": 5}},
+		{ID: "missing", Data: map[string]any{"n": 6}},
+	}, firestore_repo.WriteUpdate)
+	if !errors.As(err, &bulkErr) {
+		t.Fatalf("BulkWrite(WriteUpdate) error = %v, want *BulkWriteError", err)
+	}
+	codesByID := map[string]codes.Code{}
+	for _, failure := range bulkErr.Failures {
+		codesByID[failure.ID] = status.Code(failure.Err)
+	}
+	if codesByID["a"] != codes.FailedPrecondition || codesByID["missing"] != codes.NotFound || len(codesByID) != 2 {
+		t.Errorf("BulkWrite(WriteUpdate) failures = %v, want stale a and missing document", codesByID)
+	}
+	if result.Written != 1 || result.Failed != 2 {
+		t.Errorf("BulkWrite(WriteUpdate) result = %+v, want 1 written and 2 failed", result)
+	}
+}
+
+func TestReadPages(t *testing.T) {
+	ctx := context.Background()
+	client := firestoretest.NewClient(t)
+	ids := seedItems(t, client, "items", 1203)
+
+	var pageSizes []int
+	var readIDs []string
+	err := firestore_repo.ReadPages(ctx, client, "items", 500, func(page []*firestore.DocumentSnapshot) error {
+		pageSizes = append(pageSizes, len(page))
+		for _, doc := range page {
+			readIDs = append(readIDs, doc.Ref.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ReadPages() error = %v", err)
+	}
+	if !slices.Equal(pageSizes, []int{500, 500, 203}) {
+		t.Errorf("ReadPages() page sizes = %v, want [500 500 203]", pageSizes)
+	}
+	if !slices.Equal(readIDs, ids) {
+		t.Errorf("ReadPages() read %d IDs, want %d in order", len(readIDs), len(ids))
+	}
+
+	gotIDs, err := firestore_repo.ReadIDs(ctx, client, "items")
+	if err != nil {
+		t.Fatalf("ReadIDs() error = %v", err)
+	}
+	if !slices.Equal(gotIDs, ids) {
+		t.Errorf("ReadIDs() got %d IDs, want %d", len(gotIDs), len(ids))
+	}
+
+	sum := 0
+	err = firestore_repo.ReadInto(ctx, client, "items", func(id string, value *item) error {
+		sum += value.N
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ReadInto() error = %v", err)
+	}
+	if want := 1202 * 1203 / 2; sum != want {
+		t.Errorf("ReadInto() sum = %d, want %d", sum, want)
+	}
+}
+
+func TestReadPagesInvalidPageSize(t *testing.T) {
+	for _, pageSize := range []int{0, -1} {
+		called := false
+		err := firestore_repo.ReadPages(context.Background(), nil, "items", pageSize, func([]*firestore.DocumentSnapshot) error {
+			called = true
+			return nil
+		})
+		if err == nil || called {
+			t.Errorf("ReadPages() with page size %d error = %v, want an error before reading", pageSize, err)
+		}
+	}
+}
+
+func TestCollection(t *testing.T) {
+	ctx := context.Background()
+	client := firestoretest.NewClient(t)
+	seedItems(t, client, "items", 10)
+	collection := firestore_repo.NewCollection[item](client, "items")
+
+	got, err := collection.Get(ctx, "item-00003")
+	if err != nil || got.N != 3 {
+		t.Errorf("Get() = %+v, %v, want item 3", got, err)
+	}
+
+	if _, err := collection.Get(ctx, "missing"); !errors.Is(err, firestore_repo.ErrNotFound) {
+		t.Errorf("Get() error = %v, want ErrNotFound", err)
+	}
+
+	many, err := collection.GetMany(ctx, []string{"item-00001", "missing", "item-00002"})
+	if err != nil {
+		t.Fatalf("GetMany() error = %v", err)
+	}
+	if len(many) != 2 || many["item-00001"].N != 1 || many["item-00002"].N != 2 {
+		t.Errorf("GetMany() = %v, want items 1 and 2", many)
+	}
+
+	entries, err := collection.Query(ctx, func(q firestore.Query) firestore.Query {
+		return q.Where("n", ">=", 7).OrderBy("n", firestore.Asc)
+	})
+	if err != nil {
+		t.Fatalf("Query() error = %v", err)
+	}
+	if len(entries) != 3 || entries[0].ID != "item-00007" {
+		t.Errorf("Query() = %+v, want items 7 to 9", entries)
+	}
+
+	if _, err := collection.Put(ctx, []firestore_repo.Entry[item]{{ID: "new", Value: item{N: 42}}}, firestore_repo.WriteCreate); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	for _, value := range []item{{N: 1, Keep: "x"}, {N: 11}} {
+		if _, err := collection.Put(ctx, []firestore_repo.Entry[item]{{ID: "item-00001", Value: value}}, firestore_repo.WriteMerge); err != nil {
+			t.Fatalf("Put(WriteMerge) error = %v", err)
+		}
+	}
+	if got, _ := collection.Get(ctx, "item-00001"); got == nil || *got != (item{N: 11, Keep: "x"}) {
+		t.Errorf("after Put(WriteMerge) item-00001 = %+v, want n merged and keep left as is", got)
+	}
+
+	if err := collection.Delete(ctx, []string{"item-00000", "new"}); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+
+	ids, err := collection.IDs(ctx)
+	if err != nil {
+		t.Fatalf("IDs() error = %v", err)
+	}
+	if len(ids) != 9 || slices.Contains(ids, "item-00000") || slices.Contains(ids, "new") {
+		t.Errorf("IDs() = %v, want items 1 to 9", ids)
+	}
+}
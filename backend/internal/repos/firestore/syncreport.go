@@ -0,0 +1,62 @@
+package firestore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"cloud.google.com/go/firestore"
+	"github.com/jonwilberg/stream-finder/internal/syncreport"
+)
+
+// ReportStore keeps sync run reports in the sync_runs collection, one
+// document per run.
+type ReportStore struct {
+	client  *firestore.Client
+	reports *Collection[syncreport.Report]
+}
+
+func NewReportStore(client *firestore.Client) *ReportStore {
+	return &ReportStore{
+		client:  client,
+		reports: NewCollection[syncreport.Report](client, "sync_runs"),
+	}
+}
+
+func (s *ReportStore) Save(ctx context.Context, report *syncreport.Report) error {
+	entries := []Entry[syncreport.Report]{{ID: report.ID, Value: *report}}
+	if _, err := s.reports.Put(ctx, entries, WriteSet); err != nil {
+		return fmt.Errorf("failed to save sync report: %w", err)
+	}
+	return nil
+}
+
+func (s *ReportStore) List(ctx context.Context, limit int) ([]syncreport.Report, error) {
+	if err := syncreport.ValidateLimit(limit); err != nil {
+		return nil, err
+	}
+	entries, err := s.reports.Query(ctx, func(q firestore.Query) firestore.Query {
+		return q.OrderBy("started_at", firestore.Desc).Limit(limit)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	reports := make([]syncreport.Report, 0, len(entries))
+	for _, entry := range entries {
+		reports = append(reports, entry.Value)
+	}
+	return reports, nil
+}
+
+func (s *ReportStore) Get(ctx context.Context, id string) (*syncreport.Report, error) {
+	report, err := s.reports.Get(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		return nil, fmt.Errorf("%s: %w", id, syncreport.ErrNotFound)
+	}
+	return report, err
+}
+
+func (s *ReportStore) Close() error {
+	return s.client.Close()
+}
@@ -0,0 +1,20 @@
+package firestore_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	firestore_repo "github.com/jonwilberg/stream-finder/internal/repos/firestore"
+	"github.com/jonwilberg/stream-finder/internal/syncreport"
+)
+
+func TestReportStoreListInvalidLimit(t *testing.T) {
+	// The limit is checked before Firestore is queried, so no client is needed.
+	store := firestore_repo.NewReportStore(nil)
+	for _, limit := range []int{0, -1} {
+		if reports, err := store.List(context.Background(), limit); !errors.Is(err, syncreport.ErrInvalidLimit) {
+			t.Errorf("List(%d) = %v, %v, want ErrInvalidLimit", limit, reports, err)
+		}
+	}
+}
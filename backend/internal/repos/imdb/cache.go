@@ -0,0 +1,217 @@
+package imdb
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// cacheEntry describes a compressed dataset file kept in the cache directory.
+// It is stored next to the file with a .json suffix.
+type cacheEntry struct {
+	URL          string    `json:"url"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified time.Time `json:"last_modified,omitempty"`
+	Size         int64     `json:"size"`
+	SHA256       string    `json:"sha256"`
+}
+
+// openDataset opens the compressed dataset at dataset.URL, and records the
+// version of the dataset it got. The dataset is read from the offline
+// directory if one is set, from the cache directory if it is still current,
+// and downloaded otherwise.
+func (r *imdbRepository) openDataset(ctx context.Context, dataset *Dataset) (io.ReadCloser, error) {
+	name := filepath.Base(dataset.URL)
+	span := trace.SpanFromContext(ctx)
+
+	if r.offlineDir != "" {
+		path := filepath.Join(r.offlineDir, name)
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open offline dataset: %w", err)
+		}
+		dataset.URL = path
+		if info, err := file.Stat(); err == nil {
+			dataset.LastModified = info.ModTime().UTC()
+		}
+		span.SetAttributes(attribute.String("imdb.source", "offline"))
+		return file, nil
+	}
+
+	if r.cacheDir == "" {
+		resp, err := r.downloadFile(ctx, dataset.URL, nil)
+		if err != nil {
+			return nil, err
+		}
+		setVersion(dataset, resp.Header)
+		span.SetAttributes(attribute.String("imdb.source", "download"))
+		return resp.Body, nil
+	}
+
+	return r.openCached(ctx, dataset, filepath.Join(r.cacheDir, name))
+}
+
+// openCached opens the cached copy of the dataset at path, after downloading
+// it again unless the server reports that the cached copy is current.
+func (r *imdbRepository) openCached(ctx context.Context, dataset *Dataset, path string) (io.ReadCloser, error) {
+	span := trace.SpanFromContext(ctx)
+
+	entry, err := readCacheEntry(path)
+	if err == nil && entry.URL != dataset.URL {
+		err = errors.New("cached for another URL")
+	}
+	if err == nil {
+		err = verifyCacheEntry(path, entry)
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		slog.Warn("Ignoring cached IMDb dataset", "path", path, "error", err)
+	}
+
+	header := http.Header{}
+	if err == nil {
+		if entry.ETag != "" {
+			header.Set("If-None-Match", entry.ETag)
+		}
+		if !entry.LastModified.IsZero() {
+			header.Set("If-Modified-Since", entry.LastModified.Format(http.TimeFormat))
+		}
+	}
+
+	resp, err := r.downloadFile(ctx, dataset.URL, header)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		dataset.ETag, dataset.LastModified = entry.ETag, entry.LastModified
+		span.SetAttributes(attribute.String("imdb.source", "cache"))
+		slog.Info("Using cached IMDb dataset", "path", path)
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open cached dataset: %w", err)
+		}
+		return file, nil
+	}
+
+	setVersion(dataset, resp.Header)
+	entry = cacheEntry{URL: dataset.URL, ETag: dataset.ETag, LastModified: dataset.LastModified}
+	if err := writeCache(path, resp, &entry); err != nil {
+		return nil, err
+	}
+	span.SetAttributes(attribute.String("imdb.source", "download"))
+	slog.Info("Cached IMDb dataset", "path", path, "bytes", entry.Size)
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open cached dataset: %w", err)
+	}
+	return file, nil
+}
+
+// evictCache removes the cached copy of the dataset at url, so that it is
+// downloaded again by the next run.
+func (r *imdbRepository) evictCache(url string) {
+	if r.cacheDir == "" || r.offlineDir != "" {
+		return
+	}
+	path := filepath.Join(r.cacheDir, filepath.Base(url))
+	os.Remove(path + ".json")
+	os.Remove(path)
+}
+
+func setVersion(dataset *Dataset, header http.Header) {
+	dataset.ETag = header.Get("ETag")
+	if lastModified, err := http.ParseTime(header.Get("Last-Modified")); err == nil {
+		dataset.LastModified = lastModified
+	}
+}
+
+// writeCache writes the response body to path, checking that it is as long as
+// the response said, and fills in the size and checksum of entry. The entry
+// is written last, so that a partly written file is never taken as cached.
+func writeCache(path string, resp *http.Response, entry *cacheEntry) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	os.Remove(path + ".json")
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create cache file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), resp.Body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write cache file: %w", err)
+	}
+	if resp.ContentLength >= 0 && size != resp.ContentLength {
+		return fmt.Errorf("failed to write cache file: got %d bytes, want %d", size, resp.ContentLength)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write cache file: %w", err)
+	}
+
+	entry.Size, entry.SHA256 = size, hex.EncodeToString(hash.Sum(nil))
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode cache entry: %w", err)
+	}
+	if err := os.WriteFile(path+".json", data, 0o644); err != nil {
+		return fmt.Errorf("failed to write cache entry: %w", err)
+	}
+	return nil
+}
+
+func readCacheEntry(path string) (cacheEntry, error) {
+	data, err := os.ReadFile(path + ".json")
+	if err != nil {
+		return cacheEntry{}, err
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return cacheEntry{}, fmt.Errorf("failed to decode cache entry: %w", err)
+	}
+	return entry, nil
+}
+
+// verifyCacheEntry checks that the file at path has the size and checksum
+// recorded when it was cached.
+func verifyCacheEntry(path string, entry cacheEntry) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return fmt.Errorf("failed to read cached dataset: %w", err)
+	}
+	if size != entry.Size {
+		return fmt.Errorf("cached dataset has %d bytes, want %d", size, entry.Size)
+	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); sum != entry.SHA256 {
+		return fmt.Errorf("cached dataset has checksum %s, want %s", sum, entry.SHA256)
+	}
+	return nil
+}
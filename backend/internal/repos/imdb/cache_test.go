@@ -0,0 +1,165 @@
+package imdb
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+)
+
+const basicsTSV = "tconst\ttitleType\tprimaryTitle\toriginalTitle\tisAdult\tstartYear\tendYear\truntimeMinutes\tgenres\n" +
+	"tt0000001\tshort\tCarmencita\tCarmencita\t0\t1894\t\\N\t1\tDocumentary,Short\n" +
+	"tt0903747\ttvSeries\tBreaking Bad\tBreaking Bad\t0\t2008\t2013\t45\tCrime,Drama,Thriller\n"
+
+func gzipped(t *testing.T, data string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// datasetServer serves gzipped datasets by path with an ETag, and answers
+// conditional requests for the current version with 304 Not Modified.
+type datasetServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	datasets map[string][]byte
+	etag     string
+	// statuses are the response codes sent, in order.
+	statuses []int
+}
+
+func newDatasetServer(t *testing.T, datasets map[string]string) *datasetServer {
+	s := &datasetServer{datasets: make(map[string][]byte), etag: `"v1"`}
+	for path, data := range datasets {
+		s.datasets[path] = gzipped(t, data)
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		data, ok := s.datasets[r.URL.Path]
+		switch {
+		case !ok:
+			s.statuses = append(s.statuses, http.StatusNotFound)
+			http.NotFound(w, r)
+		case r.Header.Get("If-None-Match") == s.etag:
+			s.statuses = append(s.statuses, http.StatusNotModified)
+			w.WriteHeader(http.StatusNotModified)
+		default:
+			s.statuses = append(s.statuses, http.StatusOK)
+			w.Header().Set("ETag", s.etag)
+			w.Header().Set("Last-Modified", "Mon, 19 Oct 2026 06:00:00 GMT")
+			w.Write(data)
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *datasetServer) takeStatuses() []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	statuses := s.statuses
+	s.statuses = nil
+	return statuses
+}
+
+func TestGetTitlesCache(t *testing.T) {
+	server := newDatasetServer(t, map[string]string{"/title.basics.tsv.gz": basicsTSV})
+	cacheDir := t.TempDir()
+	repo := NewIMDBRepository(Config{
+		BaseURL:     server.URL,
+		CacheDir:    cacheDir,
+		RejectsPath: filepath.Join(t.TempDir(), "rejects.json"),
+	})
+
+	getTitles := func(wantStatuses []int) {
+		t.Helper()
+		titles, err := repo.GetTitles(context.Background())
+		if err != nil {
+			t.Fatalf("GetTitles() error = %v", err)
+		}
+		if len(titles) != 2 {
+			t.Errorf("GetTitles() got %d titles, want 2", len(titles))
+		}
+		if dataset := repo.Dataset(); dataset.ETag != `"v1"` || dataset.LastModified.IsZero() {
+			t.Errorf("Dataset() = %+v, want the version of the cached dataset", dataset)
+		}
+		if statuses := server.takeStatuses(); !slices.Equal(statuses, wantStatuses) {
+			t.Errorf("server sent %v, want %v", statuses, wantStatuses)
+		}
+	}
+
+	t.Run("first run downloads", func(t *testing.T) {
+		getTitles([]int{http.StatusOK})
+	})
+	t.Run("second run uses the cache", func(t *testing.T) {
+		getTitles([]int{http.StatusNotModified})
+	})
+	t.Run("corrupt cache is downloaded again", func(t *testing.T) {
+		path := filepath.Join(cacheDir, "title.basics.tsv.gz")
+		if err := os.WriteFile(path, []byte("corrupt"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		getTitles([]int{http.StatusOK})
+	})
+	t.Run("new version is downloaded", func(t *testing.T) {
+		server.mu.Lock()
+		server.etag = `"v2"`
+		server.mu.Unlock()
+		if _, err := repo.GetTitles(context.Background()); err != nil {
+			t.Fatalf("GetTitles() error = %v", err)
+		}
+		if statuses := server.takeStatuses(); !slices.Equal(statuses, []int{http.StatusOK}) {
+			t.Errorf("server sent %v, want a new download", statuses)
+		}
+		entry, err := readCacheEntry(filepath.Join(cacheDir, "title.basics.tsv.gz"))
+		if err != nil || entry.ETag != `"v2"` {
+			t.Errorf("readCacheEntry() = %+v, %v, want the new version", entry, err)
+		}
+	})
+}
+
+func TestGetTitlesOffline(t *testing.T) {
+	offlineDir := "../../../testdata/imdb"
+	repo := NewIMDBRepository(Config{
+		BaseURL:     "http://127.0.0.1:0",
+		OfflineDir:  offlineDir,
+		RejectsPath: filepath.Join(t.TempDir(), "rejects.json"),
+		TitleTypes:  []string{"movie", "tvSeries"},
+		MinVotes:    100_000,
+	})
+	titles, err := repo.GetTitles(context.Background())
+	if err != nil {
+		t.Fatalf("GetTitles() error = %v", err)
+	}
+
+	var ids []string
+	for _, title := range titles {
+		ids = append(ids, title.ID)
+	}
+	if want := []string{"tt0111161", "tt0903747", "tt2861424"}; !slices.Equal(ids, want) {
+		t.Errorf("GetTitles() IDs = %v, want %v", ids, want)
+	}
+	if dataset, want := repo.Dataset(), filepath.Join(offlineDir, "title.basics.tsv.gz"); dataset.URL != want {
+		t.Errorf("Dataset().URL = %q, want %q", dataset.URL, want)
+	}
+
+	repo = NewIMDBRepository(Config{OfflineDir: t.TempDir()})
+	if _, err := repo.GetTitles(context.Background()); err == nil {
+		t.Errorf("GetTitles() error = nil, want an error for a missing offline dataset")
+	}
+}
@@ -0,0 +1,210 @@
+package imdb
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// chunkSize is about how many bytes of a dataset are parsed at a time. Chunks
+// end at a line break, so they hold whole rows. Tests make it smaller.
+var chunkSize = 4 << 20
+
+// datasetReader decompresses a dataset as it is read, and remembers whether
+// reading it failed, e.g. because the download was cut off or the file is
+// corrupt.
+type datasetReader struct {
+	gzip   *gzip.Reader
+	body   io.ReadCloser
+	bytes  int64
+	failed bool
+}
+
+func newDatasetReader(body io.ReadCloser) (*datasetReader, error) {
+	gz, err := gzip.NewReader(body)
+	if err != nil {
+		body.Close()
+		return nil, fmt.Errorf("failed to create gzip reader: %w", err)
+	}
+	return &datasetReader{gzip: gz, body: body}, nil
+}
+
+func (r *datasetReader) Read(p []byte) (int, error) {
+	n, err := r.gzip.Read(p)
+	r.bytes += int64(n)
+	if err != nil && err != io.EOF {
+		r.failed = true
+	}
+	return n, err
+}
+
+func (r *datasetReader) Close() error {
+	return errors.Join(r.gzip.Close(), r.body.Close())
+}
+
+// chunk is a run of whole rows of a dataset.
+type chunk struct {
+	index int
+	// line is the line number of the first row in the file.
+	line int
+	data []byte
+}
+
+type chunkResult[T any] struct {
+	index   int
+	rows    []T
+	rejects []Reject
+}
+
+// decodeRows decodes the rows of a dataset, which have the given number of
+// fields named by the header row. The rows are parsed in chunks by up to
+// workers goroutines, or one per CPU if workers is zero, and handed to fn in
+// the order of the file. Rows that fail to decode are returned as rejects.
+func decodeRows[T any](ctx context.Context, r io.Reader, fields int, workers int, fn func(rows []T)) (Rejects, error) {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	// The header is read through br itself, as its buffer is big enough for
+	// the reader of the header to use it, so no rows are read ahead.
+	br := bufio.NewReaderSize(r, 1<<20)
+	header, err := readHeader(br)
+	if err != nil {
+		return Rejects{}, err
+	}
+
+	g, ctx := errgroup.WithContext(ctx)
+	chunks := make(chan chunk)
+	results := make(chan chunkResult[T], workers)
+
+	g.Go(func() error {
+		defer close(chunks)
+		return readChunks(ctx, br, chunks)
+	})
+
+	var parsers errgroup.Group
+	for range workers {
+		parsers.Go(func() error {
+			for c := range chunks {
+				result, err := decodeChunk[T](c, header, fields)
+				if err != nil {
+					return err
+				}
+				select {
+				case results <- result:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			return nil
+		})
+	}
+	g.Go(func() error {
+		defer close(results)
+		return parsers.Wait()
+	})
+
+	// Chunks finish out of order, so they are held until the ones before them
+	// are done.
+	var rejects Rejects
+	pending := make(map[int]chunkResult[T])
+	next := 0
+	for result := range results {
+		pending[result.index] = result
+		for {
+			result, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			next++
+
+			fn(result.rows)
+			for _, reject := range result.rejects {
+				rejects.addReject(reject)
+			}
+		}
+	}
+
+	if err := g.Wait(); err != nil {
+		return Rejects{}, err
+	}
+	return rejects, nil
+}
+
+// readChunks splits the rest of r into chunks of whole rows. The first row is
+// on line 2, after the header.
+func readChunks(ctx context.Context, r *bufio.Reader, chunks chan<- chunk) error {
+	line := 2
+	for index := 0; ; index++ {
+		data := make([]byte, chunkSize)
+		n, err := io.ReadFull(r, data)
+		data = data[:n]
+
+		eof := false
+		switch {
+		case err == nil:
+			rest, err := r.ReadBytes('\n')
+			data = append(data, rest...)
+			if err == io.EOF {
+				eof = true
+			} else if err != nil {
+				return fmt.Errorf("failed to read dataset: %w", err)
+			}
+		case err == io.EOF || err == io.ErrUnexpectedEOF:
+			// ReadFull also reports a short read as io.ErrUnexpectedEOF, so
+			// check that the end of the dataset was reached and it was not
+			// cut off.
+			if _, peekErr := r.Peek(1); peekErr != io.EOF {
+				return fmt.Errorf("failed to read dataset: %w", err)
+			}
+			eof = true
+		default:
+			return fmt.Errorf("failed to read dataset: %w", err)
+		}
+
+		if len(data) > 0 {
+			select {
+			case chunks <- chunk{index: index, line: line, data: data}:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			line += bytes.Count(data, []byte{'\n'})
+		}
+		if eof {
+			return nil
+		}
+	}
+}
+
+func decodeChunk[T any](c chunk, header []string, fields int) (chunkResult[T], error) {
+	reader := newTSVReader(bytes.NewReader(c.data), fields, c.line)
+	dec, err := newTSVDecoder(reader, header)
+	if err != nil {
+		return chunkResult[T]{}, err
+	}
+
+	result := chunkResult[T]{index: c.index, rows: make([]T, 0, len(c.data)/64)}
+	for {
+		var row T
+		if err := dec.Decode(&row); err == io.EOF {
+			break
+		} else if err != nil {
+			reject, ok := newReject(err, reader.Row())
+			if !ok {
+				return chunkResult[T]{}, fmt.Errorf("failed to decode rows: %w", err)
+			}
+			result.rejects = append(result.rejects, reject)
+		} else {
+			result.rows = append(result.rows, row)
+		}
+	}
+	return result, nil
+}
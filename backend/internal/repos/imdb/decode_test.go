@@ -0,0 +1,166 @@
+package imdb
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/jszwec/csvutil"
+)
+
+// titlesTSV returns a titles dataset with n rows. Every row whose index is a
+// multiple of badEvery, if it is above zero, has an invalid startYear.
+func titlesTSV(n int, badEvery int) string {
+	var b strings.Builder
+	b.WriteString("tconst\ttitleType\tprimaryTitle\toriginalTitle\tisAdult\tstartYear\tendYear\truntimeMinutes\tgenres\n")
+	for i := 1; i <= n; i++ {
+		year := fmt.Sprint(1900 + i%120)
+		if badEvery > 0 && i%badEvery == 0 {
+			year = "19xx"
+		}
+		fmt.Fprintf(&b, "tt%07d\tmovie\tTitle %d\tTitle %d\t0\t%s\t\\N\t90\tDrama,Comedy\n", i, i, i, year)
+	}
+	return b.String()
+}
+
+func TestDecodeRows(t *testing.T) {
+	defer func(size int) { chunkSize = size }(chunkSize)
+	chunkSize = 512
+
+	for _, workers := range []int{1, 4} {
+		t.Run(fmt.Sprintf("%d workers", workers), func(t *testing.T) {
+			var ids []string
+			calls := 0
+			rejects, err := decodeRows(context.Background(), strings.NewReader(titlesTSV(500, 100)), 9, workers, func(rows []IMDBTitle) {
+				calls++
+				for _, row := range rows {
+					ids = append(ids, row.ID)
+				}
+			})
+			if err != nil {
+				t.Fatalf("decodeRows() error = %v", err)
+			}
+
+			if calls < 10 {
+				t.Errorf("decodeRows() handed over %d chunks, want the dataset split into many", calls)
+			}
+			if len(ids) != 495 || !slices.IsSorted(ids) {
+				t.Errorf("decodeRows() got %d rows, sorted %v, want 495 in file order", len(ids), slices.IsSorted(ids))
+			}
+
+			var lines []int
+			for _, sample := range rejects.Samples {
+				lines = append(lines, sample.Line)
+				if !strings.HasPrefix(sample.Row, fmt.Sprintf("tt%07d\t", sample.Line-1)) {
+					t.Errorf("reject on line %d has row %q", sample.Line, sample.Row)
+				}
+			}
+			if want := []int{101, 201, 301, 401, 501}; rejects.Total != 5 || !slices.Equal(lines, want) {
+				t.Errorf("rejects = %d on lines %v, want 5 on lines %v", rejects.Total, lines, want)
+			}
+		})
+	}
+}
+
+func TestDecodeRowsTruncated(t *testing.T) {
+	data := gzipped(t, titlesTSV(5000, 0))
+	reader, err := newDatasetReader(io.NopCloser(bytes.NewReader(data[:len(data)/2])))
+	if err != nil {
+		t.Fatalf("newDatasetReader() error = %v", err)
+	}
+
+	_, err = decodeRows(context.Background(), reader, 9, 2, func([]IMDBTitle) {})
+	if err == nil {
+		t.Errorf("decodeRows() error = nil, want an error for a truncated dataset")
+	}
+	if !reader.failed {
+		t.Errorf("datasetReader.failed = false, want true")
+	}
+}
+
+// decodeViaTempFile is how titles were decoded before they were streamed: the
+// dataset is decompressed to a temporary file, which is then decoded row by
+// row.
+func decodeViaTempFile(b *testing.B, data []byte) int {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		b.Fatal(err)
+	}
+	file, err := os.Create(filepath.Join(b.TempDir(), "title.basics.tsv"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := io.Copy(file, gz); err != nil {
+		b.Fatal(err)
+	}
+	if _, err := file.Seek(0, 0); err != nil {
+		b.Fatal(err)
+	}
+
+	csvr := csv.NewReader(bufio.NewReader(file))
+	csvr.Comma = '\t'
+	csvr.FieldsPerRecord = 9
+	csvr.ReuseRecord = true
+	dec, err := csvutil.NewDecoder(csvr)
+	if err != nil {
+		b.Fatal(err)
+	}
+	var titles []IMDBTitle
+	for {
+		var t IMDBTitle
+		if err := dec.Decode(&t); err == io.EOF {
+			break
+		} else if err == nil {
+			titles = append(titles, t)
+		}
+	}
+	return len(titles)
+}
+
+func BenchmarkDecodeTitles(b *testing.B) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte(titlesTSV(200_000, 1000)))
+	gz.Close()
+	data := buf.Bytes()
+
+	b.Run("temp file", func(b *testing.B) {
+		for range b.N {
+			if n := decodeViaTempFile(b, data); n != 199_800 {
+				b.Fatalf("decoded %d titles", n)
+			}
+		}
+	})
+
+	for _, workers := range []int{1, 0} {
+		name := "stream"
+		if workers == 0 {
+			name = "stream parallel"
+		}
+		b.Run(name, func(b *testing.B) {
+			for range b.N {
+				reader, err := newDatasetReader(io.NopCloser(bytes.NewReader(data)))
+				if err != nil {
+					b.Fatal(err)
+				}
+				var titles []IMDBTitle
+				_, err = decodeRows(context.Background(), reader, 9, workers, func(rows []IMDBTitle) {
+					titles = append(titles, rows...)
+				})
+				if err != nil || len(titles) != 199_800 {
+					b.Fatalf("decoded %d titles, error %v", len(titles), err)
+				}
+			}
+		})
+	}
+}
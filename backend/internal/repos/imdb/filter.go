@@ -0,0 +1,180 @@
+package imdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/jonwilberg/stream-finder/internal/telemetry"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Adult policies select titles by whether they are adult.
+const (
+	// AdultInclude keeps adult titles along with the others.
+	AdultInclude = "include"
+	// AdultExclude drops adult titles.
+	AdultExclude = "exclude"
+	// AdultOnly keeps adult titles only.
+	AdultOnly = "only"
+)
+
+// YearRange is an inclusive range of start years. A zero bound leaves that
+// side of the range open.
+type YearRange struct {
+	From int
+	To   int
+}
+
+// ParseYearRange parses a range written as "1990-1999", "1990-", "-1999" or
+// a single year such as "2024".
+func ParseYearRange(s string) (YearRange, error) {
+	from, to, isRange := strings.Cut(strings.TrimSpace(s), "-")
+	if !isRange {
+		to = from
+	}
+
+	var r YearRange
+	var err error
+	if from != "" {
+		if r.From, err = strconv.Atoi(from); err != nil {
+			return YearRange{}, fmt.Errorf("invalid year range %q", s)
+		}
+	}
+	if to != "" {
+		if r.To, err = strconv.Atoi(to); err != nil {
+			return YearRange{}, fmt.Errorf("invalid year range %q", s)
+		}
+	}
+	if r == (YearRange{}) || (r.To != 0 && r.From > r.To) {
+		return YearRange{}, fmt.Errorf("invalid year range %q", s)
+	}
+	return r, nil
+}
+
+func (r YearRange) contains(year int) bool {
+	return (r.From == 0 || year >= r.From) && (r.To == 0 || year <= r.To)
+}
+
+// filter selects the titles that are indexed. Its zero value keeps every
+// title.
+type filter struct {
+	titleTypes []string
+	adult      string
+	minVotes   int
+	years      []YearRange
+	// rated holds the titles with at least minVotes votes. It is loaded from
+	// the ratings dataset before the titles are decoded.
+	rated map[string]struct{}
+}
+
+func newFilter(config Config) (filter, error) {
+	f := filter{
+		titleTypes: config.TitleTypes,
+		adult:      config.Adult,
+		minVotes:   config.MinVotes,
+	}
+
+	var errs []error
+	switch f.adult {
+	case "", AdultInclude, AdultExclude, AdultOnly:
+	default:
+		errs = append(errs, fmt.Errorf("unknown adult policy %q", f.adult))
+	}
+	if f.minVotes < 0 {
+		errs = append(errs, fmt.Errorf("min_votes must not be negative, got %d", f.minVotes))
+	}
+	for _, s := range config.Years {
+		r, err := ParseYearRange(s)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		f.years = append(f.years, r)
+	}
+	return f, errors.Join(errs...)
+}
+
+// keep reports whether the title passes the filter. Titles with an unknown
+// year are dropped if year ranges are set, and titles with an unknown adult
+// flag are treated as not adult.
+func (f filter) keep(title *IMDBTitle) bool {
+	if len(f.titleTypes) > 0 && !slices.Contains(f.titleTypes, title.TitleType) {
+		return false
+	}
+
+	switch f.adult {
+	case AdultExclude:
+		if title.IsAdult.Bool {
+			return false
+		}
+	case AdultOnly:
+		if !title.IsAdult.Bool {
+			return false
+		}
+	}
+
+	if len(f.years) > 0 {
+		if !title.Year.Valid || !slices.ContainsFunc(f.years, func(r YearRange) bool {
+			return r.contains(title.Year.Int)
+		}) {
+			return false
+		}
+	}
+
+	if f.minVotes > 0 {
+		if _, ok := f.rated[title.ID]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+type rating struct {
+	ID    string `csv:"tconst"`
+	Votes int    `csv:"numVotes"`
+}
+
+// loadRatings reads the ratings dataset into f.rated. It is a no-op if no
+// minimum vote count is set.
+func (r *imdbRepository) loadRatings(ctx context.Context, f *filter) (err error) {
+	if f.minVotes <= 0 {
+		return nil
+	}
+	ctx, span := tracer.Start(ctx, "imdb.loadRatings")
+	defer func() { telemetry.End(span, err) }()
+
+	dataset := Dataset{URL: r.baseURL + "/title.ratings.tsv.gz"}
+	body, err := r.download(ctx, &dataset)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	f.rated = make(map[string]struct{})
+	rejects, err := decodeRows(ctx, body, 3, r.decodeWorkers, func(rows []rating) {
+		for _, row := range rows {
+			if row.Votes >= f.minVotes {
+				f.rated[row.ID] = struct{}{}
+			}
+		}
+	})
+	if body.failed {
+		r.evictCache(dataset.URL)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to decode ratings: %w", err)
+	}
+	failed := rejects.Total
+
+	span.SetAttributes(
+		attribute.Int("imdb.rated", len(f.rated)),
+		attribute.Int("imdb.failed", failed),
+	)
+	slog.Info("Loaded IMDb ratings", "min_votes", f.minVotes, "titles", len(f.rated), "failed", failed)
+	return nil
+}
@@ -0,0 +1,149 @@
+package imdb
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestParseYearRange(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    YearRange
+		wantErr bool
+	}{
+		{in: "1990-1999", want: YearRange{From: 1990, To: 1999}},
+		{in: "2010-", want: YearRange{From: 2010}},
+		{in: "-1950", want: YearRange{To: 1950}},
+		{in: " 2024 ", want: YearRange{From: 2024, To: 2024}},
+		{in: "-", wantErr: true},
+		{in: "", wantErr: true},
+		{in: "1999-1990", wantErr: true},
+		{in: "199x-", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseYearRange(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseYearRange() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseYearRange() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterKeep(t *testing.T) {
+	movie := IMDBTitle{ID: "tt1", TitleType: "movie", IsAdult: NullBool{Valid: true}, Year: NullInt{Int: 2008, Valid: true}}
+	adult := IMDBTitle{ID: "tt2", TitleType: "movie", IsAdult: NullBool{Bool: true, Valid: true}, Year: NullInt{Int: 2008, Valid: true}}
+	episode := IMDBTitle{ID: "tt3", TitleType: "tvEpisode", Year: NullInt{Int: 2008, Valid: true}}
+	noYear := IMDBTitle{ID: "tt4", TitleType: "movie"}
+
+	tests := []struct {
+		name   string
+		config Config
+		rated  map[string]struct{}
+		want   []string
+	}{
+		{
+			name: "zero value keeps everything",
+			want: []string{"tt1", "tt2", "tt3", "tt4"},
+		},
+		{
+			name:   "title types",
+			config: Config{TitleTypes: []string{"movie", "tvSeries"}},
+			want:   []string{"tt1", "tt2", "tt4"},
+		},
+		{
+			name:   "exclude adult",
+			config: Config{Adult: AdultExclude},
+			want:   []string{"tt1", "tt3", "tt4"},
+		},
+		{
+			name:   "only adult",
+			config: Config{Adult: AdultOnly},
+			want:   []string{"tt2"},
+		},
+		{
+			name:   "year ranges drop unknown years",
+			config: Config{Years: []string{"1990-1999", "2005-"}},
+			want:   []string{"tt1", "tt2", "tt3"},
+		},
+		{
+			name:   "year out of range",
+			config: Config{Years: []string{"-2000"}},
+			want:   nil,
+		},
+		{
+			name:   "min votes",
+			config: Config{MinVotes: 100},
+			rated:  map[string]struct{}{"tt1": {}, "tt3": {}},
+			want:   []string{"tt1", "tt3"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := newFilter(tt.config)
+			if err != nil {
+				t.Fatalf("newFilter() error = %v", err)
+			}
+			f.rated = tt.rated
+
+			var got []string
+			for _, title := range []IMDBTitle{movie, adult, episode, noYear} {
+				if f.keep(&title) {
+					got = append(got, title.ID)
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("keep() kept %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	config := Config{Adult: "sometimes", MinVotes: -1, Years: []string{"2000-1990"}}
+	if err := config.Validate(); err == nil {
+		t.Errorf("Validate() error = nil, want an error")
+	}
+	config = Config{Adult: AdultExclude, MinVotes: 1000, Years: []string{"1990-"}}
+	if err := config.Validate(); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+}
+
+func TestGetTitlesMinVotes(t *testing.T) {
+	server := newDatasetServer(t, map[string]string{
+		"/title.basics.tsv.gz": "tconst\ttitleType\tprimaryTitle\toriginalTitle\tisAdult\tstartYear\tendYear\truntimeMinutes\tgenres\n" +
+			"tt0000001\tshort\tCarmencita\tCarmencita\t0\t1894\t\\N\t1\tDocumentary,Short\n" +
+			"tt0903747\ttvSeries\tBreaking Bad\tBreaking Bad\t0\t2008\t2013\t45\tCrime,Drama,Thriller\n" +
+			"tt0959621\ttvEpisode\tPilot\tPilot\t0\t2008\t\\N\t58\tCrime,Drama,Thriller\n",
+		"/title.ratings.tsv.gz": "tconst\taverageRating\tnumVotes\n" +
+			"tt0000001\t5.7\t2187\n" +
+			"tt0903747\t9.5\t2300000\n" +
+			"tt0959621\t9.0\t41000\n",
+	})
+
+	repo := NewIMDBRepository(Config{
+		BaseURL:     server.URL,
+		RejectsPath: filepath.Join(t.TempDir(), "rejects.json"),
+		TitleTypes:  []string{"movie", "short", "tvSeries"},
+		MinVotes:    10_000,
+	})
+	titles, err := repo.GetTitles(context.Background())
+	if err != nil {
+		t.Fatalf("GetTitles() error = %v", err)
+	}
+
+	if len(titles) != 1 || titles[0].ID != "tt0903747" {
+		t.Errorf("GetTitles() = %+v, want only Breaking Bad", titles)
+	}
+	if dataset := repo.Dataset(); dataset.Decoded != 1 || dataset.Filtered != 2 {
+		t.Errorf("Dataset() = %+v, want 1 decoded and 2 filtered", dataset)
+	}
+}
@@ -0,0 +1,96 @@
+package imdb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/jszwec/csvutil"
+)
+
+// maxSamplesPerKind is how many rejected rows are kept for each kind of
+// error, so that one common error does not hide the others.
+const maxSamplesPerKind = 5
+
+// Reject is a dataset row that could not be decoded.
+type Reject struct {
+	Line  int    `json:"line"`
+	Kind  string `json:"kind"`
+	Error string `json:"error"`
+	// Row is the raw row.
+	Row string `json:"row,omitempty"`
+}
+
+// Rejects summarizes the rows of a dataset that could not be decoded, with
+// samples of each kind of error.
+type Rejects struct {
+	Total   int            `json:"total"`
+	ByKind  map[string]int `json:"by_kind"`
+	Samples []Reject       `json:"samples"`
+}
+
+// add records a row that failed to decode, given the raw row. It returns false
+// if err is not a decode error of a single row, in which case decoding cannot
+// go on.
+func (r *Rejects) add(err error, row string) bool {
+	reject, ok := newReject(err, row)
+	if !ok {
+		return false
+	}
+	r.addReject(reject)
+	return true
+}
+
+// addReject records a row that failed to decode, keeping it as a sample if
+// there are not enough of its kind yet.
+func (r *Rejects) addReject(reject Reject) {
+	if r.ByKind == nil {
+		r.ByKind = make(map[string]int)
+	}
+	r.Total++
+	r.ByKind[reject.Kind]++
+	if r.ByKind[reject.Kind] <= maxSamplesPerKind {
+		r.Samples = append(r.Samples, reject)
+	}
+}
+
+func newReject(err error, row string) (Reject, bool) {
+	var decodeErr *csvutil.DecodeError
+	if errors.As(err, &decodeErr) {
+		return Reject{
+			Line:  decodeErr.Line,
+			Kind:  "invalid " + decodeErr.Field,
+			Error: decodeErr.Err.Error(),
+			Row:   row,
+		}, true
+	}
+
+	var rowErr *RowError
+	if errors.As(err, &rowErr) {
+		kind := "malformed row"
+		if errors.Is(rowErr.Err, ErrFieldCount) {
+			kind = "wrong field count"
+		}
+		return Reject{
+			Line:  rowErr.Line,
+			Kind:  kind,
+			Error: rowErr.Err.Error(),
+			Row:   row,
+		}, true
+	}
+
+	return Reject{}, false
+}
+
+// writeRejects writes the rejects report as JSON to path.
+func writeRejects(path string, rejects Rejects) error {
+	data, err := json.MarshalIndent(rejects, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode rejects report: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write rejects report: %w", err)
+	}
+	return nil
+}
@@ -1,34 +1,113 @@
 package imdb
 
 import (
-	"bufio"
-	"compress/gzip"
-	"encoding/csv"
+	"context"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/jonwilberg/stream-finder/internal/metrics"
+	"github.com/jonwilberg/stream-finder/internal/telemetry"
 	"github.com/jonwilberg/stream-finder/pkg/logging"
-	"github.com/jszwec/csvutil"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type IMDBRepository interface {
-	GetTitles() ([]IMDBTitle, error)
+	GetTitles(ctx context.Context) ([]IMDBTitle, error)
+	// Dataset describes the dataset read by the last call to GetTitles.
+	Dataset() Dataset
+}
+
+// Dataset describes a downloaded IMDb dataset file and how many of its rows
+// were decoded.
+type Dataset struct {
+	URL          string
+	ETag         string
+	LastModified time.Time
+	Decoded      int
+	Failed       int
+	// Filtered counts the decoded titles dropped by the ingest filter.
+	Filtered int
+	Rejects  Rejects
+}
+
+var (
+	tracer = otel.Tracer("github.com/jonwilberg/stream-finder/internal/repos/imdb")
+	meter  = otel.Meter("github.com/jonwilberg/stream-finder/internal/repos/imdb")
+
+	decodedTitles, _ = meter.Int64Counter("imdb.titles.decoded",
+		metric.WithDescription("IMDb title rows decoded, by outcome"),
+	)
+)
+
+// null is how IMDb writes a missing value. The dataset reader turns it into
+// an empty field, but the types below take either.
+const null = `\N`
+
+func isNull(data []byte) bool {
+	return len(data) == 0 || string(data) == null
 }
 
 type GenreList []string
 
 func (g *GenreList) UnmarshalCSV(data []byte) error {
-	s := string(data)
-	if s == `\N` { // IMDb uses "\N" for null
+	if isNull(data) {
 		*g = nil
 		return nil
 	}
-	*g = strings.Split(s, ",")
+	*g = strings.Split(string(data), ",")
+	return nil
+}
+
+// NullInt is an integer column that may be null. A null value decodes to
+// zero with Valid false.
+type NullInt struct {
+	Int   int
+	Valid bool
+}
+
+func (n *NullInt) UnmarshalCSV(data []byte) error {
+	if isNull(data) {
+		*n = NullInt{}
+		return nil
+	}
+	value, err := strconv.Atoi(string(data))
+	if err != nil {
+		return fmt.Errorf("invalid integer %q", data)
+	}
+	*n = NullInt{Int: value, Valid: true}
+	return nil
+}
+
+// NullBool is a 0/1 column that may be null. A null value decodes to false
+// with Valid false.
+type NullBool struct {
+	Bool  bool
+	Valid bool
+}
+
+func (n *NullBool) UnmarshalCSV(data []byte) error {
+	switch {
+	case isNull(data):
+		*n = NullBool{}
+	case string(data) == "0":
+		*n = NullBool{Bool: false, Valid: true}
+	case string(data) == "1":
+		*n = NullBool{Bool: true, Valid: true}
+	default:
+		return fmt.Errorf("invalid boolean %q", data)
+	}
 	return nil
 }
 
@@ -37,104 +116,250 @@ type IMDBTitle struct {
 	TitleType     string    `csv:"titleType"`
 	Title         string    `csv:"primaryTitle"`
 	OriginalTitle string    `csv:"originalTitle"`
-	IsAdult       bool      `csv:"isAdult"`
-	Year          int       `csv:"startYear"`
+	IsAdult       NullBool  `csv:"isAdult"`
+	Year          NullInt   `csv:"startYear"`
 	Genres        GenreList `csv:"genres"`
 }
 
-type imdbRepository struct{}
+const defaultBaseURL = "https://datasets.imdbws.com"
+
+type Config struct {
+	// BaseURL is where the IMDb datasets are downloaded from.
+	BaseURL string `config:"base_url" env:"IMDB_BASE_URL" usage:"URL the IMDb datasets are downloaded from"`
+	// RejectsPath is the file that the report of rows which failed to decode
+	// is written to.
+	RejectsPath string `config:"rejects_path" env:"IMDB_REJECTS_PATH" usage:"file the report of rows which failed to decode is written to"`
+	// CacheDir keeps the downloaded datasets between runs. They are only
+	// downloaded again when IMDb has published a new version.
+	CacheDir string `config:"cache_dir" env:"IMDB_CACHE_DIR" usage:"directory the IMDb datasets are cached in between runs"`
+	// OfflineDir holds dataset files, such as title.basics.tsv.gz, that are
+	// read instead of downloading the datasets.
+	OfflineDir string `config:"offline_dir" env:"IMDB_OFFLINE_DIR" usage:"directory of .tsv.gz dataset files to read instead of downloading them"`
+	// DecodeWorkers is how many goroutines parse the datasets, or one per CPU
+	// if zero.
+	DecodeWorkers int `config:"decode_workers" env:"IMDB_DECODE_WORKERS" usage:"goroutines that parse the IMDb datasets; one per CPU if 0"`
+
+	// TitleTypes are the title types that are indexed, or all of them if
+	// empty.
+	TitleTypes []string `config:"title_types" env:"IMDB_TITLE_TYPES" usage:"title types to index, e.g. movie,tvSeries; all if empty"`
+	// Adult is the AdultInclude, AdultExclude or AdultOnly policy for adult
+	// titles. Empty includes them.
+	Adult string `config:"adult" env:"IMDB_ADULT" usage:"adult titles: include, exclude or only"`
+	// MinVotes is the fewest IMDb votes a title needs to be indexed. Above
+	// zero, the ratings dataset is downloaded as well.
+	MinVotes int `config:"min_votes" env:"IMDB_MIN_VOTES" usage:"fewest IMDb votes a title needs to be indexed"`
+	// Years are the ranges of start years that are indexed, or all years if
+	// empty. See ParseYearRange.
+	Years []string `config:"years" env:"IMDB_YEARS" usage:"start year ranges to index, e.g. 1950-1999,2010-; all if empty"`
+}
+
+func (c Config) Validate() error {
+	_, err := newFilter(c)
+	return err
+}
 
-func NewIMDBRepository() IMDBRepository {
-	return &imdbRepository{}
+type imdbRepository struct {
+	config        Config
+	client        *http.Client
+	baseURL       string
+	userAgent     string
+	rejectsPath   string
+	cacheDir      string
+	offlineDir    string
+	decodeWorkers int
+	dataset       Dataset
 }
 
-func (r *imdbRepository) GetTitles() ([]IMDBTitle, error) {
-	url := "https://datasets.imdbws.com/title.basics.tsv.gz"
-	filepath := filepath.Join(os.TempDir(), "title.basics.tsv")
+type Option func(*imdbRepository)
 
-	resp, err := r.downloadFile(url)
-	if err != nil {
-		return nil, err
+// WithHTTPClient sets the http.Client used to download the IMDb datasets.
+func WithHTTPClient(client *http.Client) Option {
+	return func(r *imdbRepository) {
+		r.client = client
 	}
-	defer resp.Body.Close()
+}
 
-	file, err := r.unzipFile(resp, filepath)
-	if err != nil {
-		return nil, err
+// WithBaseURL sets the URL the IMDb datasets are downloaded from.
+func WithBaseURL(baseURL string) Option {
+	return func(r *imdbRepository) {
+		r.baseURL = strings.TrimSuffix(baseURL, "/")
+	}
+}
+
+// WithRejectsPath sets the file that the report of rows which failed to
+// decode is written to.
+func WithRejectsPath(path string) Option {
+	return func(r *imdbRepository) {
+		r.rejectsPath = path
 	}
-	defer file.Close()
-	defer os.Remove(filepath)
+}
 
-	return r.extractTitles(file)
+// WithUserAgent sets the User-Agent header sent when downloading datasets.
+func WithUserAgent(userAgent string) Option {
+	return func(r *imdbRepository) {
+		r.userAgent = userAgent
+	}
 }
 
-func (r *imdbRepository) downloadFile(url string) (*http.Response, error) {
-	resp, err := http.Get(url)
+func NewIMDBRepository(config Config, opts ...Option) IMDBRepository {
+	r := &imdbRepository{
+		config:        config,
+		client:        http.DefaultClient,
+		baseURL:       defaultBaseURL,
+		rejectsPath:   filepath.Join(os.TempDir(), "imdb_rejects.json"),
+		cacheDir:      config.CacheDir,
+		offlineDir:    config.OfflineDir,
+		decodeWorkers: config.DecodeWorkers,
+	}
+	if config.BaseURL != "" {
+		r.baseURL = strings.TrimSuffix(config.BaseURL, "/")
+	}
+	if config.RejectsPath != "" {
+		r.rejectsPath = config.RejectsPath
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	// Downloads are traced, but the trace context is not sent to IMDb.
+	client := *r.client
+	client.Transport = otelhttp.NewTransport(client.Transport,
+		otelhttp.WithPropagators(propagation.NewCompositeTextMapPropagator()),
+	)
+	r.client = &client
+
+	return r
+}
+
+func (r *imdbRepository) GetTitles(ctx context.Context) (titles []IMDBTitle, err error) {
+	ctx, span := tracer.Start(ctx, "imdb.GetTitles")
+	defer func() { telemetry.End(span, err) }()
+
+	filter, err := newFilter(r.config)
 	if err != nil {
-		return nil, fmt.Errorf("failed to download file: %w", err)
+		return nil, fmt.Errorf("invalid ingest filter: %w", err)
+	}
+	if err := r.loadRatings(ctx, &filter); err != nil {
+		return nil, err
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		resp.Body.Close()
-		return nil, fmt.Errorf("failed to download file: status code %d", resp.StatusCode)
+	r.dataset = Dataset{URL: r.baseURL + "/title.basics.tsv.gz"}
+	body, err := r.download(ctx, &r.dataset)
+	if err != nil {
+		return nil, err
 	}
+	defer body.Close()
 
-	return resp, nil
+	titles, err = r.extractTitles(ctx, body, filter)
+	if body.failed {
+		r.evictCache(r.dataset.URL)
+	}
+	span.SetAttributes(attribute.Int64("imdb.decompressed_bytes", body.bytes))
+	return titles, err
+}
+
+func (r *imdbRepository) Dataset() Dataset {
+	return r.dataset
 }
 
-func (r *imdbRepository) unzipFile(resp *http.Response, filepath string) (*os.File, error) {
-	gzipReader, err := gzip.NewReader(resp.Body)
+// download opens the dataset at dataset.URL, to be decompressed as it is
+// read, and records the version of the dataset it got. See openDataset for
+// where it is fetched from.
+func (r *imdbRepository) download(ctx context.Context, dataset *Dataset) (reader *datasetReader, err error) {
+	ctx, span := tracer.Start(ctx, "imdb.download", trace.WithAttributes(
+		attribute.String("url.full", dataset.URL),
+	))
+	defer func() { telemetry.End(span, err) }()
+
+	body, err := r.openDataset(ctx, dataset)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create gzip reader: %w", err)
+		return nil, err
 	}
-	defer gzipReader.Close()
+	reader, err = newDatasetReader(body)
+	if err != nil {
+		r.evictCache(dataset.URL)
+		return nil, err
+	}
+	return reader, nil
+}
 
-	file, err := os.Create(filepath)
+// downloadFile requests the file at url with the given extra headers. A
+// conditional request may get a 304 Not Modified response.
+func (r *imdbRepository) downloadFile(ctx context.Context, url string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create file: %w", err)
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if r.userAgent != "" {
+		req.Header.Set("User-Agent", r.userAgent)
 	}
 
-	if _, err := io.Copy(file, gzipReader); err != nil {
-		file.Close()
-		return nil, fmt.Errorf("failed to write file: %w", err)
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download file: %w", err)
 	}
 
-	if _, err := file.Seek(0, 0); err != nil {
-		file.Close()
-		return nil, fmt.Errorf("failed to seek to start of file: %w", err)
+	notModified := resp.StatusCode == http.StatusNotModified && len(header) > 0
+	if resp.StatusCode != http.StatusOK && !notModified {
+		resp.Body.Close()
+		return nil, fmt.Errorf("failed to download file: status code %d", resp.StatusCode)
 	}
 
-	return file, nil
+	return resp, nil
 }
 
-func (r *imdbRepository) extractTitles(file *os.File) ([]IMDBTitle, error) {
-	bufReader := bufio.NewReader(file)
-	csvr := csv.NewReader(bufReader)
-	csvr.Comma = '\t'
-	csvr.FieldsPerRecord = 9
-	csvr.ReuseRecord = true
+// extractTitles decodes the titles dataset and keeps the titles that pass the
+// filter.
+func (r *imdbRepository) extractTitles(ctx context.Context, file io.Reader, filter filter) (titles []IMDBTitle, err error) {
+	ctx, span := tracer.Start(ctx, "imdb.decode")
+	defer func() { telemetry.End(span, err) }()
 
-	dec, err := csvutil.NewDecoder(csvr)
+	titles = make([]IMDBTitle, 0, 1_000_000)
+	filtered := 0
+	bar := logging.NewProgress("Decoding IMDb titles", -1)
+	rejects, err := decodeRows(ctx, file, 9, r.decodeWorkers, func(rows []IMDBTitle) {
+		for i := range rows {
+			if filter.keep(&rows[i]) {
+				titles = append(titles, rows[i])
+			} else {
+				filtered++
+			}
+		}
+		bar.Add(len(rows))
+	})
+	bar.Finish()
 	if err != nil {
-		return nil, fmt.Errorf("failed to create csv decoder: %w", err)
-	}
-
-	titles := make([]IMDBTitle, 0, 12_000_000)
-	failed := 0
-	bar := logging.NewProgressBar("Decoding IMDb titles", -1)
-	for {
-		var t IMDBTitle
-		if err := dec.Decode(&t); err == io.EOF {
-			break
-		} else if err != nil {
-			failed++
+		return nil, fmt.Errorf("failed to decode titles: %w", err)
+	}
+	failed := rejects.Total
+
+	span.SetAttributes(
+		attribute.Int("imdb.titles", len(titles)),
+		attribute.Int("imdb.failed", failed),
+		attribute.Int("imdb.filtered", filtered),
+	)
+	decodedTitles.Add(ctx, int64(len(titles)), metric.WithAttributes(attribute.String("outcome", "decoded")))
+	decodedTitles.Add(ctx, int64(failed), metric.WithAttributes(attribute.String("outcome", "failed")))
+	decodedTitles.Add(ctx, int64(filtered), metric.WithAttributes(attribute.String("outcome", "filtered")))
+	metrics.TitlesFetched.WithLabelValues("imdb").Add(float64(len(titles)))
+	metrics.DecodeFailures.Add(float64(failed))
+	r.dataset.Decoded, r.dataset.Failed, r.dataset.Filtered, r.dataset.Rejects = len(titles), failed, filtered, rejects
+
+	slog.Info("Decoded IMDb titles", "count", len(titles), "failed", failed, "filtered", filtered)
+	if failed > 0 {
+		for kind, count := range rejects.ByKind {
+			slog.Warn("IMDb rows failed to decode", "kind", kind, "count", count)
+		}
+		// The rejects report is only diagnostics, so a read-only filesystem
+		// does not fail the sync.
+		if err := writeRejects(r.rejectsPath, rejects); err != nil {
+			slog.Warn("Failed to write IMDb rejects report", "error", err)
 		} else {
-			titles = append(titles, t)
-			bar.Add(1)
+			slog.Warn("Wrote IMDb rows that failed to decode", "count", failed, "path", r.rejectsPath)
 		}
 	}
-	bar.Finish()
-
-	slog.Info("Decoded IMDb titles", "count", len(titles), "failed", failed)
 	return titles, nil
 }
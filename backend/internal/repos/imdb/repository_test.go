@@ -0,0 +1,152 @@
+package imdb
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/jonwilberg/stream-finder/pkg/vcr"
+)
+
+func TestGetTitles(t *testing.T) {
+	recorder, err := vcr.New(filepath.Join("../../../testdata/cassettes", "imdb_title_basics.json"), vcr.ModeFromEnv(), nil)
+	if err != nil {
+		t.Fatalf("Failed to create recorder: %v", err)
+	}
+
+	repo := NewIMDBRepository(Config{RejectsPath: filepath.Join(t.TempDir(), "rejects.json")}, WithHTTPClient(recorder.Client()))
+	got, err := repo.GetTitles(context.Background())
+	if err != nil {
+		t.Fatalf("GetTitles() error = %v", err)
+	}
+
+	if err := recorder.Stop(); err != nil {
+		t.Errorf("recorder.Stop() error = %v", err)
+	}
+
+	expected := []IMDBTitle{
+		{
+			ID:            "tt0000001",
+			TitleType:     "short",
+			Title:         "Carmencita",
+			OriginalTitle: "Carmencita",
+			IsAdult:       NullBool{Valid: true},
+			Year:          NullInt{Int: 1894, Valid: true},
+			Genres:        GenreList{"Documentary", "Short"},
+		},
+		{
+			ID:            "tt0903747",
+			TitleType:     "tvSeries",
+			Title:         "Breaking Bad",
+			OriginalTitle: "Breaking Bad",
+			IsAdult:       NullBool{Valid: true},
+			Year:          NullInt{Int: 2008, Valid: true},
+			Genres:        GenreList{"Crime", "Drama", "Thriller"},
+		},
+		{
+			ID:            "tt9999999",
+			TitleType:     "movie",
+			Title:         "Untitled Project",
+			OriginalTitle: "Untitled Project",
+			IsAdult:       NullBool{Valid: true},
+		},
+	}
+
+	dataset := repo.Dataset()
+	if dataset.URL != defaultBaseURL+"/title.basics.tsv.gz" || dataset.Decoded != len(got) {
+		t.Errorf("Dataset() = %+v, want the title.basics URL and %d decoded titles", dataset, len(got))
+	}
+
+	if len(got) != len(expected) {
+		t.Fatalf("GetTitles() got %d titles, want %d", len(got), len(expected))
+	}
+	for i, title := range got {
+		want := expected[i]
+		if title.ID != want.ID || title.TitleType != want.TitleType || title.Title != want.Title ||
+			title.OriginalTitle != want.OriginalTitle || title.IsAdult != want.IsAdult || title.Year != want.Year {
+			t.Errorf("GetTitles()[%d] = %+v, want %+v", i, title, want)
+		}
+		if len(title.Genres) != len(want.Genres) {
+			t.Errorf("GetTitles()[%d].Genres = %v, want %v", i, title.Genres, want.Genres)
+		}
+	}
+}
+
+func TestExtractTitlesRejects(t *testing.T) {
+	const header = "tconst\ttitleType\tprimaryTitle\toriginalTitle\tisAdult\tstartYear\tendYear\truntimeMinutes\tgenres\n"
+	tsv := header +
+		"tt0000001\tshort\tCarmencita\tCarmencita\t0\t1894\t\\N\t1\tDocumentary,Short\n" +
+		"tt0000002\tshort\tLe clown\tLe clown\tyes\t1892\t\\N\t5\tAnimation\n" +
+		"tt0000003\tshort\tPauvre Pierrot\n" +
+		"tt0000004\tmovie\tNo Year\tNo Year\t\\N\t\\N\t\\N\t\\N\t\\N\n" +
+		"tt0000005\tshort\tUn bon bock\tUn bon bock\t0\t189x\t\\N\t12\tAnimation\n"
+
+	path := filepath.Join(t.TempDir(), "rejects.json")
+	repo := &imdbRepository{rejectsPath: path}
+	titles, err := repo.extractTitles(context.Background(), strings.NewReader(tsv), filter{})
+	if err != nil {
+		t.Fatalf("extractTitles() error = %v", err)
+	}
+
+	var ids []string
+	for _, title := range titles {
+		ids = append(ids, title.ID)
+	}
+	if want := []string{"tt0000001", "tt0000004"}; !slices.Equal(ids, want) {
+		t.Errorf("extractTitles() IDs = %v, want %v", ids, want)
+	}
+	if titles[1].Year.Valid || titles[1].IsAdult.Valid {
+		t.Errorf("extractTitles()[1] = %+v, want a null year and isAdult", titles[1])
+	}
+
+	wantKinds := map[string]int{"invalid isAdult": 1, "wrong field count": 1, "invalid startYear": 1}
+	wantLines := []int{3, 4, 6}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read rejects report: %v", err)
+	}
+	var rejects Rejects
+	if err := json.Unmarshal(data, &rejects); err != nil {
+		t.Fatalf("Failed to decode rejects report: %v", err)
+	}
+	if rejects.Total != 3 || !maps.Equal(rejects.ByKind, wantKinds) {
+		t.Errorf("rejects = %d %v, want 3 %v", rejects.Total, rejects.ByKind, wantKinds)
+	}
+	var lines []int
+	for _, sample := range rejects.Samples {
+		lines = append(lines, sample.Line)
+	}
+	if !slices.Equal(lines, wantLines) {
+		t.Errorf("rejects lines = %v, want %v", lines, wantLines)
+	}
+	if row := rejects.Samples[0].Row; !strings.HasPrefix(row, "tt0000002\t") {
+		t.Errorf("rejects.Samples[0].Row = %q, want the raw row", row)
+	}
+	if dataset := repo.Dataset(); dataset.Failed != 3 || dataset.Decoded != 2 {
+		t.Errorf("Dataset() = %+v, want 2 decoded and 3 failed", dataset)
+	}
+}
+
+func TestRejectsSamplesPerKind(t *testing.T) {
+	var rejects Rejects
+	for line := 1; line <= maxSamplesPerKind+3; line++ {
+		err := &RowError{Line: line, Err: ErrFieldCount}
+		if !rejects.add(err, "") {
+			t.Fatalf("add() = false, want true")
+		}
+	}
+	if rejects.add(io.ErrUnexpectedEOF, "") {
+		t.Errorf("add(io.ErrUnexpectedEOF) = true, want false")
+	}
+
+	if rejects.Total != maxSamplesPerKind+3 || len(rejects.Samples) != maxSamplesPerKind {
+		t.Errorf("rejects = %d with %d samples, want %d with %d", rejects.Total, len(rejects.Samples), maxSamplesPerKind+3, maxSamplesPerKind)
+	}
+}
@@ -0,0 +1,161 @@
+package imdb
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/jszwec/csvutil"
+)
+
+// ErrFieldCount is the error of a row with the wrong number of fields.
+var ErrFieldCount = errors.New("wrong number of fields")
+
+// RowError is an error reading a row of a dataset file.
+type RowError struct {
+	Line int
+	// Row is the raw row.
+	Row string
+	Err error
+}
+
+func (e *RowError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *RowError) Unwrap() error {
+	return e.Err
+}
+
+// tsvReader reads the rows of an IMDb dataset file. The files are tab
+// separated but are not CSV: a quote is part of the field it is in, and fields
+// never hold a tab or line break, so every line is a row. \N, which IMDb
+// writes for a missing value, is read as an empty field in any column.
+//
+// It implements csvutil.Reader, so rows can be decoded into structs with
+// newTSVDecoder.
+type tsvReader struct {
+	r      *bufio.Reader
+	fields int
+	// line is the line number of the last row read, and row the raw row.
+	line    int
+	row     string
+	record  []string
+	columns []int
+	buf     []byte
+}
+
+// newTSVReader reads rows with the given number of fields, or any number if
+// fields is zero. firstLine is the line number of the first row in r.
+func newTSVReader(r io.Reader, fields int, firstLine int) *tsvReader {
+	return &tsvReader{
+		r:      bufio.NewReaderSize(r, 64<<10),
+		fields: fields,
+		line:   firstLine - 1,
+	}
+}
+
+// Read returns the next row. A row with the wrong number of fields is returned
+// along with a *RowError for ErrFieldCount. The returned slice is reused by
+// the next call.
+func (t *tsvReader) Read() ([]string, error) {
+	var line []byte
+	for len(line) == 0 {
+		var err error
+		line, err = t.readLine()
+		if err != nil {
+			return nil, err
+		}
+		t.line++
+	}
+
+	// The fields are cut out of one string per row, which saves allocating
+	// one per field.
+	row := string(line)
+	t.row = row
+	t.record, t.columns = t.record[:0], t.columns[:0]
+	column := 0
+	for {
+		i := strings.IndexByte(row[column:], '\t')
+		end := len(row)
+		if i >= 0 {
+			end = column + i
+		}
+		field := row[column:end]
+		if field == null {
+			field = ""
+		}
+		t.record = append(t.record, field)
+		t.columns = append(t.columns, column+1)
+		if i < 0 {
+			break
+		}
+		column = end + 1
+	}
+
+	if t.fields > 0 && len(t.record) != t.fields {
+		err := fmt.Errorf("%w: got %d, want %d", ErrFieldCount, len(t.record), t.fields)
+		return t.record, &RowError{Line: t.line, Row: row, Err: err}
+	}
+	return t.record, nil
+}
+
+// Row returns the last row read as it is in the file.
+func (t *tsvReader) Row() string {
+	return t.row
+}
+
+// FieldPos returns the line and column of a field of the last row read, which
+// csvutil adds to its decode errors.
+func (t *tsvReader) FieldPos(field int) (line, column int) {
+	if field < 0 || field >= len(t.columns) {
+		return t.line, 0
+	}
+	return t.line, t.columns[field]
+}
+
+// readLine returns the next line without its line break. The last line may
+// have none.
+func (t *tsvReader) readLine() ([]byte, error) {
+	line, err := t.r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		t.buf = append(t.buf[:0], line...)
+		for err == bufio.ErrBufferFull {
+			line, err = t.r.ReadSlice('\n')
+			t.buf = append(t.buf, line...)
+		}
+		line = t.buf
+	}
+	if err == io.EOF && len(line) > 0 {
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	line = bytes.TrimSuffix(line, []byte{'\n'})
+	return bytes.TrimSuffix(line, []byte{'\r'}), nil
+}
+
+// newTSVDecoder decodes the rows read by r, which have fields named by header,
+// into structs.
+func newTSVDecoder(r *tsvReader, header []string) (*csvutil.Decoder, error) {
+	dec, err := csvutil.NewDecoder(r, header...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create decoder: %w", err)
+	}
+	return dec, nil
+}
+
+// readHeader reads the header row of a dataset file, which names its fields.
+func readHeader(r io.Reader) ([]string, error) {
+	header, err := newTSVReader(r, 0, 1).Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+	return slices.Clone(header), nil
+}
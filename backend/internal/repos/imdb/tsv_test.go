@@ -0,0 +1,171 @@
+package imdb
+
+import (
+	"errors"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestTSVReader(t *testing.T) {
+	longTitle := strings.Repeat("a", 100<<10)
+
+	tests := []struct {
+		name     string
+		in       string
+		want     [][]string
+		wantLine int
+		wantErr  error
+	}{
+		{
+			name: "quotes are part of the field",
+			in:   "tt1\t\"Weird Al\" Yankovic\t5'2\" Tall\n",
+			want: [][]string{{"tt1", `"Weird Al" Yankovic`, `5'2" Tall`}},
+		},
+		{
+			name: "unbalanced quotes do not join lines",
+			in:   "tt1\t\"\tThe \"Boss\n" + "tt2\tb\t\"\n",
+			want: [][]string{{"tt1", `"`, `The "Boss`}, {"tt2", "b", `"`}},
+		},
+		{
+			name: "nulls are empty",
+			in:   "tt1\t\\N\t\\N\n",
+			want: [][]string{{"tt1", "", ""}},
+		},
+		{
+			name: "backslashes are kept",
+			in:   "tt1\tAC\\DC\t\\Nope\n",
+			want: [][]string{{"tt1", `AC\DC`, `\Nope`}},
+		},
+		{
+			name: "empty fields",
+			in:   "tt1\t\t\n",
+			want: [][]string{{"tt1", "", ""}},
+		},
+		{
+			name: "CRLF and no final line break",
+			in:   "tt1\ta\tb\r\ntt2\tc\td",
+			want: [][]string{{"tt1", "a", "b"}, {"tt2", "c", "d"}},
+		},
+		{
+			name: "blank lines are skipped",
+			in:   "tt1\ta\tb\n\ntt2\tc\td\n",
+			want: [][]string{{"tt1", "a", "b"}, {"tt2", "c", "d"}},
+		},
+		{
+			name: "lines longer than the buffer",
+			in:   "tt1\t" + longTitle + "\tb\n",
+			want: [][]string{{"tt1", longTitle, "b"}},
+		},
+		{
+			name:     "wrong field count",
+			in:       "tt1\ta\tb\ntt2\tc\n",
+			want:     [][]string{{"tt1", "a", "b"}},
+			wantLine: 11,
+			wantErr:  ErrFieldCount,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTSVReader(strings.NewReader(tt.in), 3, 10)
+			var got [][]string
+			var err error
+			for {
+				var record []string
+				record, err = r.Read()
+				if err != nil {
+					break
+				}
+				got = append(got, slices.Clone(record))
+			}
+
+			if tt.wantErr == nil && err != io.EOF {
+				t.Fatalf("Read() error = %v, want io.EOF", err)
+			}
+			if tt.wantErr != nil {
+				var rowErr *RowError
+				if !errors.Is(err, tt.wantErr) || !errors.As(err, &rowErr) || rowErr.Line != tt.wantLine || rowErr.Row != "tt2\tc" {
+					t.Fatalf("Read() error = %#v, want a RowError on line %d for %v", err, tt.wantLine, tt.wantErr)
+				}
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Read() got %d rows, want %d", len(got), len(tt.want))
+			}
+			for i := range got {
+				if !slices.Equal(got[i], tt.want[i]) {
+					t.Errorf("Read() row %d = %q, want %q", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestTSVDecoder(t *testing.T) {
+	header := []string{"tconst", "titleType", "primaryTitle", "originalTitle", "isAdult", "startYear", "endYear", "runtimeMinutes", "genres"}
+	rows := "tt0000010\tmovie\t\"Weird\": The Al Yankovic Story\t\"Weird\": The Al Yankovic Story\t0\t2022\t\\N\t108\tBiography,Comedy,Music\n" +
+		"tt0000011\ttvSeries\t\"\tFoo \"Bar\t\\N\t\\N\t\\N\t\\N\t\\N\n" +
+		"tt0000012\tmovie\tBad Year\tBad Year\t0\t20x2\t\\N\t90\tDrama\n" +
+		"tt0000013\tmovie\tToo Few\n"
+
+	reader := newTSVReader(strings.NewReader(rows), 9, 2)
+	dec, err := newTSVDecoder(reader, header)
+	if err != nil {
+		t.Fatalf("newTSVDecoder() error = %v", err)
+	}
+
+	var titles []IMDBTitle
+	var rejects Rejects
+	for {
+		var title IMDBTitle
+		if err := dec.Decode(&title); err == io.EOF {
+			break
+		} else if err != nil {
+			if !rejects.add(err, reader.Row()) {
+				t.Fatalf("Decode() error = %v", err)
+			}
+		} else {
+			titles = append(titles, title)
+		}
+	}
+
+	want := []IMDBTitle{
+		{
+			ID:            "tt0000010",
+			TitleType:     "movie",
+			Title:         `"Weird": The Al Yankovic Story`,
+			OriginalTitle: `"Weird": The Al Yankovic Story`,
+			IsAdult:       NullBool{Valid: true},
+			Year:          NullInt{Int: 2022, Valid: true},
+			Genres:        GenreList{"Biography", "Comedy", "Music"},
+		},
+		{
+			ID:            "tt0000011",
+			TitleType:     "tvSeries",
+			Title:         `"`,
+			OriginalTitle: `Foo "Bar`,
+		},
+	}
+	if len(titles) != len(want) {
+		t.Fatalf("Decode() got %d titles, want %d", len(titles), len(want))
+	}
+	for i := range want {
+		got := titles[i]
+		if got.ID != want[i].ID || got.Title != want[i].Title || got.OriginalTitle != want[i].OriginalTitle ||
+			got.IsAdult != want[i].IsAdult || got.Year != want[i].Year || !slices.Equal(got.Genres, want[i].Genres) {
+			t.Errorf("Decode()[%d] = %+v, want %+v", i, got, want[i])
+		}
+	}
+
+	var lines []int
+	for _, sample := range rejects.Samples {
+		lines = append(lines, sample.Line)
+	}
+	if rejects.Samples[0].Row != "tt0000012\tmovie\tBad Year\tBad Year\t0\t20x2\t\\N\t90\tDrama" {
+		t.Errorf("rejects.Samples[0].Row = %q, want the raw row", rejects.Samples[0].Row)
+	}
+	if !slices.Equal(lines, []int{4, 5}) || rejects.ByKind["invalid startYear"] != 1 || rejects.ByKind["wrong field count"] != 1 {
+		t.Errorf("rejects = %+v, want a bad startYear on line 4 and a short row on line 5", rejects)
+	}
+}
@@ -2,49 +2,221 @@ package netflix
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"mime/multipart"
 	"net/http"
-	"os"
+	"regexp"
 	"strconv"
+	"strings"
+
+	"github.com/jonwilberg/stream-finder/internal/metrics"
+	"github.com/jonwilberg/stream-finder/internal/telemetry"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/jonwilberg/stream-finder/internal/repos/netflix")
+
+var (
+	ErrMissingCredentials = errors.New("NETFLIX_ID and NETFLIX_SECURE_ID must be set to the NetflixId and SecureNetflixId cookies of a logged-in browser session")
+	ErrSessionExpired     = errors.New("netflix session is not logged in, refresh the NETFLIX_ID and NETFLIX_SECURE_ID cookies from a logged-in browser session")
+	ErrNotMember          = errors.New("netflix account has no active membership, so only the logged-out catalog is visible")
+)
+
+type NetflixSession struct {
+	ProfileName      string
+	ProfileGUID      string
+	Country          string
+	MembershipStatus string
+}
+
+// Config holds the cookies of a logged-in Netflix browser session.
+type Config struct {
+	NetflixID       string `config:"netflix_id" env:"NETFLIX_ID" secret:"true"`
+	SecureNetflixID string `config:"secure_netflix_id" env:"NETFLIX_SECURE_ID" secret:"true"`
+}
+
+func (c Config) Validate() error {
+	if c.NetflixID == "" || c.SecureNetflixID == "" {
+		return ErrMissingCredentials
+	}
+	return nil
+}
+
+const (
+	defaultBaseURL        = "https://www.netflix.com"
+	defaultGraphQLBaseURL = "https://web.prod.cloud.netflix.com"
 )
 
 type NetflixClient struct {
 	netflixID       string
 	netflixSecureID string
 	client          *http.Client
+	baseURL         string
+	graphQLBaseURL  string
+	userAgent       string
+}
+
+type ClientOption func(*NetflixClient)
+
+// WithHTTPClient sets the http.Client used for all requests to Netflix.
+func WithHTTPClient(client *http.Client) ClientOption {
+	return func(c *NetflixClient) {
+		c.client = client
+	}
+}
+
+// WithBaseURL sets the URL of the Netflix website, which serves the session
+// check and the pathEvaluator API.
+func WithBaseURL(baseURL string) ClientOption {
+	return func(c *NetflixClient) {
+		c.baseURL = strings.TrimSuffix(baseURL, "/")
+	}
+}
+
+// WithGraphQLBaseURL sets the URL of the Netflix GraphQL API.
+func WithGraphQLBaseURL(baseURL string) ClientOption {
+	return func(c *NetflixClient) {
+		c.graphQLBaseURL = strings.TrimSuffix(baseURL, "/")
+	}
 }
 
-func NewClient() *NetflixClient {
-	return &NetflixClient{
-		netflixID:       os.Getenv("NETFLIX_ID"),
-		netflixSecureID: os.Getenv("NETFLIX_SECURE_ID"),
+// WithUserAgent sets the User-Agent header sent with every request.
+func WithUserAgent(userAgent string) ClientOption {
+	return func(c *NetflixClient) {
+		c.userAgent = userAgent
+	}
+}
+
+func NewClient(config Config, opts ...ClientOption) *NetflixClient {
+	c := &NetflixClient{
+		netflixID:       config.NetflixID,
+		netflixSecureID: config.SecureNetflixID,
 		client:          &http.Client{},
+		baseURL:         defaultBaseURL,
+		graphQLBaseURL:  defaultGraphQLBaseURL,
 	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	// Requests are traced, but the trace context is not sent to Netflix.
+	client := *c.client
+	client.Transport = otelhttp.NewTransport(metrics.InstrumentNetflixTransport(client.Transport),
+		otelhttp.WithPropagators(propagation.NewCompositeTextMapPropagator()),
+	)
+	c.client = &client
+
+	return c
 }
 
-func (c *NetflixClient) MakeGenreRequest(genreID string, offset int, batchSize int) ([]byte, error) {
-	url := "https://www.netflix.com/nq/website/memberapi/release/pathEvaluator?original_path=%2Fshakti%2Fmre%2FpathEvaluator"
+// Validate checks that the session cookies belong to a logged-in member and
+// returns the profile and country the catalog will be crawled for.
+func (c *NetflixClient) Validate(ctx context.Context) (*NetflixSession, error) {
+	if c.netflixID == "" || c.netflixSecureID == "" {
+		return nil, ErrMissingCredentials
+	}
 
-	formBody := &bytes.Buffer{}
-	writer := multipart.NewWriter(formBody)
+	req, err := http.NewRequestWithContext(ctx, "GET", c.baseURL+"/browse", nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %v", err)
+	}
 
-	part, err := writer.CreateFormField("path")
+	c.setSessionHeaders(req)
+
+	// Logged-out sessions are redirected to the login page, which we want to
+	// see rather than follow.
+	client := *c.client
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	resp, err := client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("error creating form field: %v", err)
+		return nil, fmt.Errorf("error making request: %v", err)
 	}
+	defer resp.Body.Close()
 
-	pathStr := fmt.Sprintf(`["genres",%s,"su",{"from":%d,"to":%d},"reference",["availability","episodeCount","queue","summary"]]`,
-		genreID, offset, offset+batchSize)
-	if _, err := part.Write([]byte(pathStr)); err != nil {
-		return nil, fmt.Errorf("error writing form field: %v", err)
+	if resp.StatusCode >= 300 && resp.StatusCode < 400 {
+		return nil, ErrSessionExpired
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response body: %v", err)
+	}
+
+	session := extractSession(body)
+	switch session.MembershipStatus {
+	case "CURRENT_MEMBER":
+		return session, nil
+	case "", "ANONYMOUS":
+		return nil, ErrSessionExpired
+	default:
+		return nil, fmt.Errorf("%w (membership status %s)", ErrNotMember, session.MembershipStatus)
+	}
+}
+
+// MakeGenreRequest requests the videos at indices offset to
+// offset+batchSize-1 of a genre list, along with the length of the list.
+func (c *NetflixClient) MakeGenreRequest(ctx context.Context, genreID string, offset int, batchSize int) (body []byte, err error) {
+	ctx, span := tracer.Start(ctx, "netflix.MakeGenreRequest", trace.WithAttributes(
+		attribute.String("netflix.genre_id", genreID),
+		attribute.Int("netflix.offset", offset),
+		attribute.Int("netflix.batch_size", batchSize),
+	))
+	defer func() { telemetry.End(span, err) }()
+
+	videosPath := fmt.Sprintf(`["genres",%s,"su",{"from":%d,"to":%d},"reference",["availability","episodeCount","queue","summary"]]`,
+		genreID, offset, offset+batchSize-1)
+	lengthPath := fmt.Sprintf(`["genres",%s,"su","length"]`, genreID)
+	return c.makePathEvaluatorRequest(ctx, videosPath, lengthPath)
+}
+
+func (c *NetflixClient) MakeSubgenresRequest(ctx context.Context, genreID string, offset int, batchSize int) (body []byte, err error) {
+	ctx, span := tracer.Start(ctx, "netflix.MakeSubgenresRequest", trace.WithAttributes(
+		attribute.String("netflix.genre_id", genreID),
+		attribute.Int("netflix.offset", offset),
+		attribute.Int("netflix.batch_size", batchSize),
+	))
+	defer func() { telemetry.End(span, err) }()
+
+	pathStr := fmt.Sprintf(`["genres",%s,"subgenres",{"from":%d,"to":%d},"summary"]`,
+		genreID, offset, offset+batchSize-1)
+	return c.makePathEvaluatorRequest(ctx, pathStr)
+}
+
+func (c *NetflixClient) makePathEvaluatorRequest(ctx context.Context, paths ...string) ([]byte, error) {
+	url := c.baseURL + "/nq/website/memberapi/release/pathEvaluator?original_path=%2Fshakti%2Fmre%2FpathEvaluator"
+
+	formBody := &bytes.Buffer{}
+	writer := multipart.NewWriter(formBody)
+
+	for _, pathStr := range paths {
+		part, err := writer.CreateFormField("path")
+		if err != nil {
+			return nil, fmt.Errorf("error creating form field: %v", err)
+		}
+
+		if _, err := part.Write([]byte(pathStr)); err != nil {
+			return nil, fmt.Errorf("error writing form field: %v", err)
+		}
 	}
 
 	writer.Close()
 
-	req, err := http.NewRequest("POST", url, formBody)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, formBody)
 	if err != nil {
 		return nil, fmt.Errorf("error creating request: %v", err)
 	}
@@ -52,10 +224,7 @@ func (c *NetflixClient) MakeGenreRequest(genreID string, offset int, batchSize i
 	req.Header.Set("Content-Type", writer.FormDataContentType())
 	req.Header.Set("Host", "netflix.com")
 	req.Header.Set("Content-Length", strconv.Itoa(formBody.Len()))
-	cookie := fmt.Sprintf("SecureNetflixId=%s; NetflixId=%s",
-		c.netflixSecureID,
-		c.netflixID)
-	req.Header.Set("Cookie", cookie)
+	c.setSessionHeaders(req)
 
 	resp, err := c.client.Do(req)
 	if err != nil {
@@ -75,8 +244,13 @@ func (c *NetflixClient) MakeGenreRequest(genreID string, offset int, batchSize i
 	return body, nil
 }
 
-func (c *NetflixClient) MakeMiniModalRequest(unifiedEntityIds []string) ([]byte, error) {
-	url := "https://web.prod.cloud.netflix.com/graphql"
+func (c *NetflixClient) MakeMiniModalRequest(ctx context.Context, unifiedEntityIds []string) (body []byte, err error) {
+	ctx, span := tracer.Start(ctx, "netflix.MakeMiniModalRequest", trace.WithAttributes(
+		attribute.Int("netflix.videos", len(unifiedEntityIds)),
+	))
+	defer func() { telemetry.End(span, err) }()
+
+	url := c.graphQLBaseURL + "/graphql"
 
 	requestBody := map[string]any{
 		"operationName": "MiniModalQuery",
@@ -104,7 +278,7 @@ func (c *NetflixClient) MakeMiniModalRequest(unifiedEntityIds []string) ([]byte,
 		return nil, fmt.Errorf("error marshaling request body: %v", err)
 	}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonBody))
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonBody))
 	if err != nil {
 		return nil, fmt.Errorf("error creating request: %v", err)
 	}
@@ -112,10 +286,7 @@ func (c *NetflixClient) MakeMiniModalRequest(unifiedEntityIds []string) ([]byte,
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Host", "netflix.com")
 	req.Header.Set("Content-Length", strconv.Itoa(len(jsonBody)))
-	cookie := fmt.Sprintf("SecureNetflixId=%s; NetflixId=%s",
-		c.netflixSecureID,
-		c.netflixID)
-	req.Header.Set("Cookie", cookie)
+	c.setSessionHeaders(req)
 
 	resp, err := c.client.Do(req)
 	if err != nil {
@@ -127,10 +298,57 @@ func (c *NetflixClient) MakeMiniModalRequest(unifiedEntityIds []string) ([]byte,
 		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
 	}
 
-	body, err := io.ReadAll(resp.Body)
+	body, err = io.ReadAll(resp.Body)
 	if err != nil {
 		return nil, fmt.Errorf("error reading response body: %v", err)
 	}
 
 	return body, nil
 }
+
+func (c *NetflixClient) setSessionHeaders(req *http.Request) {
+	cookie := fmt.Sprintf("SecureNetflixId=%s; NetflixId=%s",
+		c.netflixSecureID,
+		c.netflixID)
+	req.Header.Set("Cookie", cookie)
+	if c.userAgent != "" {
+		req.Header.Set("User-Agent", c.userAgent)
+	}
+}
+
+var (
+	membershipStatusPattern = regexp.MustCompile(`"membershipStatus":"([^"]*)"`)
+	currentCountryPattern   = regexp.MustCompile(`"currentCountry":"([^"]*)"`)
+	userGUIDPattern         = regexp.MustCompile(`"userGuid":"([^"]*)"`)
+	profileNamePattern      = regexp.MustCompile(`"userInfo":\{"data":\{"name":"((?:[^"\\]|\\.)*)"`)
+	hexEscapePattern        = regexp.MustCompile(`\\x([0-9A-Fa-f]{2})`)
+)
+
+// extractSession reads the member details from the reactContext that the
+// browse page embeds as a JavaScript object literal.
+func extractSession(page []byte) *NetflixSession {
+	find := func(pattern *regexp.Regexp) string {
+		match := pattern.FindSubmatch(page)
+		if match == nil {
+			return ""
+		}
+		return unescapeJS(string(match[1]))
+	}
+
+	return &NetflixSession{
+		ProfileName:      find(profileNamePattern),
+		ProfileGUID:      find(userGUIDPattern),
+		Country:          find(currentCountryPattern),
+		MembershipStatus: find(membershipStatusPattern),
+	}
+}
+
+func unescapeJS(s string) string {
+	return hexEscapePattern.ReplaceAllStringFunc(s, func(escape string) string {
+		b, err := strconv.ParseUint(escape[2:], 16, 8)
+		if err != nil {
+			return escape
+		}
+		return string(rune(b))
+	})
+}
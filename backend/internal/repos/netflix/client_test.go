@@ -0,0 +1,124 @@
+package netflix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	memberPage := `<script>netflix.reactContext = {"models":{"userInfo":{"data":{"name":"Jon","userGuid":"XYZ123","membershipStatus":"CURRENT_MEMBER","currentCountry":"NO"}}}};</script>`
+	formerMemberPage := `<script>netflix.reactContext = {"models":{"userInfo":{"data":{"membershipStatus":"FORMER_MEMBER","currentCountry":"NO"}}}};</script>`
+
+	tests := []struct {
+		name      string
+		netflixID string
+		handler   http.HandlerFunc
+		expected  *NetflixSession
+		wantErr   error
+	}{
+		{
+			name:      "missing credentials",
+			netflixID: "",
+			wantErr:   ErrMissingCredentials,
+		},
+		{
+			name:      "redirected to login",
+			netflixID: "id",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.Redirect(w, r, "/login", http.StatusFound)
+			},
+			wantErr: ErrSessionExpired,
+		},
+		{
+			name:      "former member",
+			netflixID: "id",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, formerMemberPage)
+			},
+			wantErr: ErrNotMember,
+		},
+		{
+			name:      "current member",
+			netflixID: "id",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/browse" {
+					t.Errorf("unexpected path %s", r.URL.Path)
+				}
+				if r.Header.Get("User-Agent") != "stream-finder-test" {
+					t.Errorf("unexpected user agent %q", r.Header.Get("User-Agent"))
+				}
+				if r.Header.Get("Cookie") != "SecureNetflixId=secure; NetflixId=id" {
+					t.Errorf("unexpected cookie %q", r.Header.Get("Cookie"))
+				}
+				fmt.Fprint(w, memberPage)
+			},
+			expected: &NetflixSession{
+				ProfileName:      "Jon",
+				ProfileGUID:      "XYZ123",
+				Country:          "NO",
+				MembershipStatus: "CURRENT_MEMBER",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(tt.handler)
+			defer server.Close()
+
+			client := NewClient(Config{NetflixID: tt.netflixID, SecureNetflixID: "secure"}, WithBaseURL(server.URL), WithUserAgent("stream-finder-test"))
+			got, err := client.Validate(context.Background())
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Validate() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if *got != *tt.expected {
+				t.Errorf("Validate() = %+v, want %+v", *got, *tt.expected)
+			}
+		})
+	}
+}
+
+func TestExtractSession(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    []byte
+		expected NetflixSession
+	}{
+		{
+			name:     "logged out page",
+			input:    []byte(`<html><script>netflix.reactContext = {"models":{"userInfo":{"data":{"membershipStatus":"ANONYMOUS","currentCountry":"NO"}}}};</script></html>`),
+			expected: NetflixSession{Country: "NO", MembershipStatus: "ANONYMOUS"},
+		},
+		{
+			name:  "member page",
+			input: []byte(`<script>netflix.reactContext = {"models":{"userInfo":{"data":{"name":"Jon\x20W\x27s","guid":"ABC","userGuid":"XYZ123","membershipStatus":"CURRENT_MEMBER","countryOfSignup":"NO","currentCountry":"SE"}}}};</script>`),
+			expected: NetflixSession{
+				ProfileName:      "Jon W's",
+				ProfileGUID:      "XYZ123",
+				Country:          "SE",
+				MembershipStatus: "CURRENT_MEMBER",
+			},
+		},
+		{
+			name:     "no react context",
+			input:    []byte(`<html></html>`),
+			expected: NetflixSession{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := extractSession(tt.input)
+			if *got != tt.expected {
+				t.Errorf("extractSession() = %+v, want %+v", *got, tt.expected)
+			}
+		})
+	}
+}
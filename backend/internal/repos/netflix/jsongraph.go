@@ -0,0 +1,91 @@
+package netflix
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// jsonGraphRef is a reference from one path of a pathEvaluator jsonGraph
+// response to another. Indices without a value come back as bare atoms.
+type jsonGraphRef struct {
+	Type  string   `json:"$type"`
+	Value []string `json:"value"`
+}
+
+// genreListPage is one page of a genre's video list. Length is -1 if the
+// response did not include the length of the list.
+type genreListPage struct {
+	Length   int
+	VideoIDs map[int]string
+}
+
+func extractGenreListPage(response []byte, genreID string) (genreListPage, error) {
+	var result struct {
+		JSONGraph struct {
+			Genres map[string]struct {
+				SU map[string]json.RawMessage `json:"su"`
+			} `json:"genres"`
+		} `json:"jsonGraph"`
+	}
+
+	if err := json.Unmarshal(response, &result); err != nil {
+		return genreListPage{}, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	page := genreListPage{
+		Length:   -1,
+		VideoIDs: make(map[int]string),
+	}
+
+	for key, raw := range result.JSONGraph.Genres[genreID].SU {
+		if key == "length" {
+			length, err := extractLength(raw)
+			if err != nil {
+				return genreListPage{}, fmt.Errorf("failed to extract list length: %w", err)
+			}
+			page.Length = length
+			continue
+		}
+
+		index, err := strconv.Atoi(key)
+		if err != nil {
+			continue
+		}
+
+		var entry struct {
+			Reference jsonGraphRef `json:"reference"`
+		}
+		if err := json.Unmarshal(raw, &entry); err != nil {
+			return genreListPage{}, fmt.Errorf("failed to unmarshal list entry %d: %w", index, err)
+		}
+
+		ref := entry.Reference
+		if ref.Type != "ref" || len(ref.Value) != 2 || ref.Value[0] != "videos" {
+			continue
+		}
+		page.VideoIDs[index] = "Video:" + ref.Value[1]
+	}
+
+	return page, nil
+}
+
+// extractLength reads a list length, which is sent either as a plain number
+// or wrapped in an atom.
+func extractLength(raw json.RawMessage) (int, error) {
+	var length int
+	if err := json.Unmarshal(raw, &length); err == nil {
+		return length, nil
+	}
+
+	var atom struct {
+		Value *int `json:"value"`
+	}
+	if err := json.Unmarshal(raw, &atom); err != nil {
+		return 0, err
+	}
+	if atom.Value == nil {
+		return 0, fmt.Errorf("length atom has no value")
+	}
+	return *atom.Value, nil
+}
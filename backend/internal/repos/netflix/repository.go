@@ -1,119 +1,322 @@
 package netflix
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
-	"regexp"
+	"slices"
+	"strconv"
 
+	"github.com/jonwilberg/stream-finder/internal/metrics"
+	"github.com/jonwilberg/stream-finder/internal/telemetry"
 	"github.com/jonwilberg/stream-finder/pkg/datatools"
 	"github.com/jonwilberg/stream-finder/pkg/logging"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type NetflixRepository interface {
-	GetTitles() ([]NetflixTitle, error)
+	Validate(ctx context.Context) (*NetflixSession, error)
+	GetGenres(ctx context.Context) ([]NetflixGenre, error)
+	GetTitles(ctx context.Context) ([]NetflixTitle, error)
 }
 
 type NetflixTitle struct {
-	ID    string
-	Title string
-	Year  int
+	ID     string
+	Title  string
+	Year   int
+	Genres []string
+}
+
+type NetflixGenre struct {
+	ID       string
+	Name     string
+	ParentID string
 }
 
 type netflixRepository struct {
 	client *NetflixClient
 }
 
-func NewNetflixRepository() NetflixRepository {
-	client := NewClient()
+var rootGenres = []NetflixGenre{
+	{ID: "34399", Name: "Movies"},
+	{ID: "83", Name: "TV Shows"},
+}
+
+const (
+	genreBatchSize     = 100
+	genreListBatchSize = 100
+	miniModalBatchSize = 100
+)
+
+// IncompleteGenreError reports indices of a genre list that Netflix did not
+// return, which means the titles crawled for that genre are incomplete.
+type IncompleteGenreError struct {
+	GenreID string
+	Length  int
+	Missing []int
+}
+
+func (e *IncompleteGenreError) Error() string {
+	return fmt.Sprintf("genre %s is missing %d of %d titles", e.GenreID, len(e.Missing), e.Length)
+}
+
+func NewNetflixRepository(config Config, opts ...ClientOption) NetflixRepository {
+	client := NewClient(config, opts...)
 	return &netflixRepository{
 		client: client,
 	}
 }
 
-func (r *netflixRepository) GetTitles() ([]NetflixTitle, error) {
-	allTitles := []NetflixTitle{}
+func (r *netflixRepository) Validate(ctx context.Context) (*NetflixSession, error) {
+	return r.client.Validate(ctx)
+}
+
+// GetGenres walks the genre tree breadth-first from the root genres. Genres
+// are listed under several parents, so each genre is only visited once.
+func (r *netflixRepository) GetGenres(ctx context.Context) (genres []NetflixGenre, err error) {
+	ctx, span := tracer.Start(ctx, "netflix.GetGenres")
+	defer func() { telemetry.End(span, err) }()
+
+	seen := make(map[string]struct{})
+	queue := append([]NetflixGenre{}, rootGenres...)
 
-	genres := []string{
-		"34399", // Movies
-		"83",    // Series,
+	for len(queue) > 0 {
+		genre := queue[0]
+		queue = queue[1:]
+
+		if _, ok := seen[genre.ID]; ok {
+			continue
+		}
+		seen[genre.ID] = struct{}{}
+		genres = append(genres, genre)
+
+		subgenres, err := r.getSubgenres(ctx, genre.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get subgenres of genre %s: %w", genre.ID, err)
+		}
+		queue = append(queue, subgenres...)
 	}
 
-	for _, genreID := range genres {
-		titles, err := r.GetGenreTitles(genreID)
+	span.SetAttributes(attribute.Int("netflix.genres", len(genres)))
+	slog.Info("Fetched genres from Netflix", "count", len(genres))
+	return genres, nil
+}
+
+func (r *netflixRepository) getSubgenres(ctx context.Context, genreID string) ([]NetflixGenre, error) {
+	var subgenres []NetflixGenre
+	for offset := 0; ; offset += genreBatchSize {
+		body, err := r.client.MakeSubgenresRequest(ctx, genreID, offset, genreBatchSize)
 		if err != nil {
+			return nil, fmt.Errorf("failed to make subgenres request: %w", err)
+		}
+
+		batch, err := extractSubgenres(body, genreID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to extract subgenres: %w", err)
+		}
+
+		subgenres = append(subgenres, batch...)
+		if len(batch) < genreBatchSize {
+			return subgenres, nil
+		}
+	}
+}
+
+// GetTitles crawls every genre in the genre tree and returns each title once,
+// together with the IDs of all genres it is listed under. The crawl fails if
+// any genre list has gaps, as the result would not be the full catalog.
+func (r *netflixRepository) GetTitles(ctx context.Context) (titles []NetflixTitle, err error) {
+	ctx, span := tracer.Start(ctx, "netflix.GetTitles")
+	defer func() { telemetry.End(span, err) }()
+
+	genres, err := r.GetGenres(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get genres: %w", err)
+	}
+
+	var videoIDs []string
+	var incomplete []error
+	titleGenres := make(map[string][]string)
+	for _, genre := range genres {
+		genreVideoIDs, err := r.GetGenreVideoIDs(ctx, genre.ID)
+		var incompleteErr *IncompleteGenreError
+		if errors.As(err, &incompleteErr) {
+			slog.Warn("Netflix genre list has gaps",
+				"genre", genre.ID,
+				"length", incompleteErr.Length,
+				"missing", len(incompleteErr.Missing),
+			)
+			incomplete = append(incomplete, err)
+		} else if err != nil {
 			return nil, fmt.Errorf("failed to get titles: %w", err)
 		}
-		allTitles = append(allTitles, titles...)
+
+		for _, videoID := range genreVideoIDs {
+			if _, ok := titleGenres[videoID]; !ok {
+				videoIDs = append(videoIDs, videoID)
+			}
+			titleGenres[videoID] = append(titleGenres[videoID], genre.ID)
+		}
 	}
 
-	allUniqueTitles := datatools.Unique(allTitles)
-	slog.Info("Fetched titles from Netflix", "count", len(allUniqueTitles))
+	span.SetAttributes(
+		attribute.Int("netflix.genres", len(genres)),
+		attribute.Int("netflix.videos", len(videoIDs)),
+		attribute.Int("netflix.incomplete_genres", len(incomplete)),
+	)
+
+	if len(incomplete) > 0 {
+		return nil, fmt.Errorf("netflix crawl is incomplete: %w", errors.Join(incomplete...))
+	}
 
-	return allUniqueTitles, nil
+	titles, err = r.getTitleDetails(ctx, videoIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get title details: %w", err)
+	}
+
+	for i := range titles {
+		titles[i].Genres = datatools.Unique(titleGenres[titles[i].ID])
+	}
+
+	span.SetAttributes(attribute.Int("netflix.titles", len(titles)))
+	metrics.TitlesFetched.WithLabelValues("netflix").Add(float64(len(titles)))
+	slog.Info("Fetched titles from Netflix", "count", len(titles), "genres", len(genres))
+	return titles, nil
 }
 
-func (r *netflixRepository) GetGenreTitles(genreID string) ([]NetflixTitle, error) {
-	batchSize := 100
-	offset := 0
-	var allTitles []NetflixTitle
+// GetGenreVideoIDs pages through the video list of a genre. If Netflix does
+// not return every index up to the length of the list, the IDs that were
+// returned are passed back together with an *IncompleteGenreError.
+func (r *netflixRepository) GetGenreVideoIDs(ctx context.Context, genreID string) (videoIDs []string, err error) {
+	ctx, span := tracer.Start(ctx, "netflix.GetGenreVideoIDs", trace.WithAttributes(
+		attribute.String("netflix.genre_id", genreID),
+	))
+	defer func() { telemetry.End(span, err) }()
 
-	bar := logging.NewProgressBar(fmt.Sprintf("Fetching titles from Netflix genre %s", genreID), -1)
+	length := -1
+	var allVideoIDs []string
+	var missing []int
 
-	for {
-		titles, err := r.getGenreTitlesBatch(genreID, offset, batchSize)
+	bar := logging.NewProgress(fmt.Sprintf("Fetching titles from Netflix genre %s", genreID), -1)
+
+	for offset := 0; length < 0 || offset < length; offset += genreListBatchSize {
+		body, err := r.client.MakeGenreRequest(ctx, genreID, offset, genreListBatchSize)
 		if err != nil {
-			return nil, fmt.Errorf("failed to get movies titles: %w", err)
+			return nil, fmt.Errorf("failed to make genre request: %w", err)
 		}
 
-		if len(titles) == 0 {
-			break
+		page, err := extractGenreListPage(body, genreID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to extract genre list: %w", err)
 		}
 
-		allTitles = append(allTitles, titles...)
-		bar.Add(len(titles))
-		offset += batchSize + 1
+		if length < 0 {
+			if page.Length < 0 {
+				return nil, fmt.Errorf("genre %s response has no list length", genreID)
+			}
+			length = page.Length
+		}
+
+		end := min(offset+genreListBatchSize, length)
+		for i := offset; i < end; i++ {
+			videoID, ok := page.VideoIDs[i]
+			if !ok {
+				missing = append(missing, i)
+				continue
+			}
+			allVideoIDs = append(allVideoIDs, videoID)
+		}
+		bar.Add(len(page.VideoIDs))
 	}
 
 	bar.Finish()
 
-	return allTitles, nil
-}
+	span.SetAttributes(
+		attribute.Int("netflix.length", length),
+		attribute.Int("netflix.videos", len(allVideoIDs)),
+		attribute.Int("netflix.missing", len(missing)),
+	)
 
-func (r *netflixRepository) getGenreTitlesBatch(genreID string, offset int, batchSize int) ([]NetflixTitle, error) {
-	body, err := r.client.MakeGenreRequest(genreID, offset, batchSize)
-	if err != nil {
-		return nil, fmt.Errorf("failed to make genre request: %w", err)
+	if len(missing) > 0 {
+		return allVideoIDs, &IncompleteGenreError{
+			GenreID: genreID,
+			Length:  length,
+			Missing: missing,
+		}
 	}
 
-	videoIDs := extractVideoIDs(body)
-	if len(videoIDs) == 0 {
-		return []NetflixTitle{}, nil
-	}
+	return allVideoIDs, nil
+}
 
-	miniModalData, err := r.client.MakeMiniModalRequest(videoIDs)
-	if err != nil {
-		return nil, fmt.Errorf("failed to make mini modal request: %w", err)
-	}
+func (r *netflixRepository) getTitleDetails(ctx context.Context, videoIDs []string) ([]NetflixTitle, error) {
+	titles := make([]NetflixTitle, 0, len(videoIDs))
+	bar := logging.NewProgress("Fetching Netflix title details", len(videoIDs))
 
-	titles, err := extractTitles(miniModalData)
-	if err != nil {
-		return nil, fmt.Errorf("failed to extract titles: %w", err)
+	for start := 0; start < len(videoIDs); start += miniModalBatchSize {
+		end := min(start+miniModalBatchSize, len(videoIDs))
+
+		miniModalData, err := r.client.MakeMiniModalRequest(ctx, videoIDs[start:end])
+		if err != nil {
+			return nil, fmt.Errorf("failed to make mini modal request: %w", err)
+		}
+
+		batch, err := extractTitles(miniModalData)
+		if err != nil {
+			return nil, fmt.Errorf("failed to extract titles: %w", err)
+		}
+
+		titles = append(titles, batch...)
+		bar.Add(end - start)
 	}
 
+	bar.Finish()
+
 	return titles, nil
 }
 
-func extractVideoIDs(response []byte) []string {
-	re := regexp.MustCompile(`Video:(\d+)`)
-	matches := re.FindAllStringSubmatch(string(response), -1)
+func extractSubgenres(response []byte, genreID string) ([]NetflixGenre, error) {
+	var result struct {
+		JSONGraph struct {
+			Genres map[string]struct {
+				Subgenres map[string]jsonGraphRef `json:"subgenres"`
+				Summary   struct {
+					Value struct {
+						MenuName string `json:"menuName"`
+					} `json:"value"`
+				} `json:"summary"`
+			} `json:"genres"`
+		} `json:"jsonGraph"`
+	}
+
+	if err := json.Unmarshal(response, &result); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
 
-	videoIDs := make([]string, 0, len(matches))
-	for _, match := range matches {
-		videoIDs = append(videoIDs, "Video:"+match[1])
+	refs := result.JSONGraph.Genres[genreID].Subgenres
+	indices := make([]int, 0, len(refs))
+	for key, ref := range refs {
+		index, err := strconv.Atoi(key)
+		if err != nil || ref.Type != "ref" || len(ref.Value) != 2 {
+			continue
+		}
+		indices = append(indices, index)
+	}
+	slices.Sort(indices)
+
+	subgenres := make([]NetflixGenre, 0, len(indices))
+	for _, index := range indices {
+		subgenreID := refs[strconv.Itoa(index)].Value[1]
+		subgenres = append(subgenres, NetflixGenre{
+			ID:       subgenreID,
+			Name:     result.JSONGraph.Genres[subgenreID].Summary.Value.MenuName,
+			ParentID: genreID,
+		})
 	}
 
-	return videoIDs
+	return subgenres, nil
 }
 
 func extractTitles(response []byte) ([]NetflixTitle, error) {
@@ -1,70 +1,98 @@
 package netflix
 
 import (
+	"context"
+	"errors"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+
+	"github.com/jonwilberg/stream-finder/pkg/vcr"
 )
 
-func TestExtractVideoIDs(t *testing.T) {
+func TestExtractGenreListPage(t *testing.T) {
 	sampleData, err := os.ReadFile(filepath.Join("../../../testdata", "netflix_genre_sample.json"))
 	if err != nil {
 		t.Fatalf("Failed to read sample data: %v", err)
 	}
 
 	tests := []struct {
-		name     string
-		input    []byte
-		expected []string
+		name           string
+		input          []byte
+		expectedLength int
+		expected       map[int]string
+		wantErr        bool
 	}{
 		{
-			name:     "empty response",
-			input:    []byte(""),
-			expected: []string{},
+			name:    "empty response",
+			input:   []byte(""),
+			wantErr: true,
 		},
 		{
-			name:     "no video IDs",
-			input:    []byte(`{"some": "json"}`),
-			expected: []string{},
+			name:           "no genre list",
+			input:          []byte(`{"some": "json"}`),
+			expectedLength: -1,
+			expected:       map[int]string{},
 		},
 		{
-			name:     "single video ID",
-			input:    []byte(`{"videos": ["Video:12345"]}`),
-			expected: []string{"Video:12345"},
+			name:           "length as atom",
+			input:          []byte(`{"jsonGraph": {"genres": {"34399": {"su": {"length": {"$type": "atom", "value": 250}}}}}}`),
+			expectedLength: 250,
+			expected:       map[int]string{},
 		},
 		{
-			name:     "multiple video IDs",
-			input:    []byte(`{"videos": ["Video:12345", "Video:67890", "Video:11111"]}`),
-			expected: []string{"Video:12345", "Video:67890", "Video:11111"},
+			name:           "length as number",
+			input:          []byte(`{"jsonGraph": {"genres": {"34399": {"su": {"length": 250}}}}}`),
+			expectedLength: 250,
+			expected:       map[int]string{},
 		},
 		{
-			name:     "video IDs in different formats",
-			input:    []byte(`{"videos": ["Video:12345", "some text", "Video:67890", "more text", "Video:11111"]}`),
-			expected: []string{"Video:12345", "Video:67890", "Video:11111"},
+			name: "missing index",
+			input: []byte(`{"jsonGraph": {"genres": {"34399": {"su": {
+				"length": 3,
+				"0": {"reference": {"$type": "ref", "value": ["videos", "12345"]}},
+				"1": {"$type": "atom"},
+				"2": {"reference": {"$type": "ref", "value": ["videos", "67890"]}}
+			}}}}}`),
+			expectedLength: 3,
+			expected:       map[int]string{0: "Video:12345", 2: "Video:67890"},
 		},
 		{
-			name:     "real API response",
-			input:    sampleData,
-			expected: []string{"Video:80121192", "Video:81743369"},
+			name:           "ignores video references outside the genre list",
+			input:          []byte(`{"jsonGraph": {"genres": {"34399": {"su": {"length": 0}}}, "videos": {"12345": {"summary": {"$type": "atom", "value": {"unifiedEntityId": "Video:12345"}}}}}}`),
+			expectedLength: 0,
+			expected:       map[int]string{},
+		},
+		{
+			name:           "real API response",
+			input:          sampleData,
+			expectedLength: -1,
+			expected:       map[int]string{0: "Video:80121192", 1: "Video:81743369"},
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := extractVideoIDs(tt.input)
-			if len(got) != len(tt.expected) {
-				t.Errorf("extractVideoIDs() got %d IDs, want %d", len(got), len(tt.expected))
+			got, err := extractGenreListPage(tt.input, "34399")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("extractGenreListPage() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
-
-			expectedMap := make(map[string]struct{}, len(tt.expected))
-			for _, id := range tt.expected {
-				expectedMap[id] = struct{}{}
+			if tt.wantErr {
+				return
 			}
-
-			for _, id := range got {
-				if _, exists := expectedMap[id]; !exists {
-					t.Errorf("extractVideoIDs() got unexpected ID: %s", id)
+			if got.Length != tt.expectedLength {
+				t.Errorf("extractGenreListPage() length = %d, want %d", got.Length, tt.expectedLength)
+			}
+			if len(got.VideoIDs) != len(tt.expected) {
+				t.Errorf("extractGenreListPage() got %d IDs, want %d", len(got.VideoIDs), len(tt.expected))
+				return
+			}
+			for index, id := range tt.expected {
+				if got.VideoIDs[index] != id {
+					t.Errorf("extractGenreListPage() index %d = %q, want %q", index, got.VideoIDs[index], id)
 				}
 			}
 		})
@@ -147,3 +175,146 @@ func TestExtractTitles(t *testing.T) {
 		})
 	}
 }
+
+func TestExtractSubgenres(t *testing.T) {
+	sampleData, err := os.ReadFile(filepath.Join("../../../testdata", "netflix_subgenres_sample.json"))
+	if err != nil {
+		t.Fatalf("Failed to read sample data: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		input    []byte
+		genreID  string
+		expected []NetflixGenre
+		wantErr  bool
+	}{
+		{
+			name:    "invalid JSON",
+			input:   []byte(`{"invalid": json}`),
+			genreID: "34399",
+			wantErr: true,
+		},
+		{
+			name:     "unknown genre",
+			input:    sampleData,
+			genreID:  "83",
+			expected: []NetflixGenre{},
+		},
+		{
+			name:    "real API response",
+			input:   sampleData,
+			genreID: "34399",
+			expected: []NetflixGenre{
+				{ID: "1365", Name: "Action & Adventure", ParentID: "34399"},
+				{ID: "6548", Name: "Comedies", ParentID: "34399"},
+				{ID: "5763", Name: "Dramas", ParentID: "34399"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := extractSubgenres(tt.input, tt.genreID)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("extractSubgenres() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(got) != len(tt.expected) {
+				t.Errorf("extractSubgenres() got %d genres, want %d", len(got), len(tt.expected))
+				return
+			}
+			for i, genre := range got {
+				if genre != tt.expected[i] {
+					t.Errorf("extractSubgenres()[%d] = %+v, want %+v", i, genre, tt.expected[i])
+				}
+			}
+		})
+	}
+}
+
+func TestGetTitles(t *testing.T) {
+	tests := []struct {
+		name           string
+		cassette       string
+		expectedCount  int
+		expectedGenres map[string][]string
+		wantErr        func(error) bool
+	}{
+		{
+			name:          "multi-page crawl with shared subgenre",
+			cassette:      "netflix_full_crawl.json",
+			expectedCount: 152,
+			expectedGenres: map[string][]string{
+				"Video:1000": {"34399", "1365"},
+				"Video:1149": {"34399"},
+				"Video:2000": {"83", "1365"},
+				"Video:2001": {"83"},
+			},
+		},
+		{
+			name:     "gap in genre list",
+			cassette: "netflix_genre_gap.json",
+			wantErr: func(err error) bool {
+				var incompleteErr *IncompleteGenreError
+				return errors.As(err, &incompleteErr) &&
+					incompleteErr.GenreID == "83" &&
+					slices.Equal(incompleteErr.Missing, []int{1})
+			},
+		},
+		{
+			name:     "server error",
+			cassette: "netflix_server_error.json",
+			wantErr: func(err error) bool {
+				return strings.Contains(err.Error(), "unexpected status code: 503")
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder, err := vcr.New(filepath.Join("../../../testdata/cassettes", tt.cassette), vcr.ModeFromEnv(), nil)
+			if err != nil {
+				t.Fatalf("Failed to create recorder: %v", err)
+			}
+
+			repo := NewNetflixRepository(Config{}, WithHTTPClient(recorder.Client()))
+			got, err := repo.GetTitles(context.Background())
+
+			if err := recorder.Stop(); err != nil {
+				t.Errorf("recorder.Stop() error = %v", err)
+			}
+
+			if tt.wantErr != nil {
+				if err == nil || !tt.wantErr(err) {
+					t.Errorf("GetTitles() error = %v, want matching error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GetTitles() error = %v", err)
+			}
+
+			if len(got) != tt.expectedCount {
+				t.Errorf("GetTitles() got %d titles, want %d", len(got), tt.expectedCount)
+			}
+
+			byID := make(map[string]NetflixTitle, len(got))
+			for _, title := range got {
+				if _, exists := byID[title.ID]; exists {
+					t.Errorf("GetTitles() returned %s more than once", title.ID)
+				}
+				byID[title.ID] = title
+			}
+
+			for id, genres := range tt.expectedGenres {
+				if !slices.Equal(byID[id].Genres, genres) {
+					t.Errorf("GetTitles() %s genres = %v, want %v", id, byID[id].Genres, genres)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,326 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/jonwilberg/stream-finder/internal/availability"
+	_ "modernc.org/sqlite"
+)
+
+var providerPattern = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// timestampLayout is fixed width, so that timestamps stored as text in UTC
+// sort chronologically.
+const timestampLayout = "2006-01-02T15:04:05.000000000Z07:00"
+
+// AvailabilityStore keeps the titles of a provider in a SQLite or Postgres
+// database. The queries only use syntax that both understand.
+type AvailabilityStore struct {
+	db           *sql.DB
+	titlesTable  string
+	historyTable string
+}
+
+// Open connects to the database and creates the tables of the provider if
+// they do not exist. The driver is "sqlite" or "pgx".
+func Open(ctx context.Context, driver string, dsn string, provider string) (*AvailabilityStore, error) {
+	if !providerPattern.MatchString(provider) {
+		return nil, fmt.Errorf("invalid provider name %q", provider)
+	}
+
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	s := &AvailabilityStore{
+		db:           db,
+		titlesTable:  provider + "_titles",
+		historyTable: provider + "_title_history",
+	}
+
+	if err := s.migrate(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *AvailabilityStore) migrate(ctx context.Context) error {
+	statements := []string{
+		`CREATE TABLE IF NOT EXISTS ` + s.titlesTable + ` (
+			id TEXT PRIMARY KEY,
+			title TEXT NOT NULL,
+			year INTEGER NOT NULL,
+			updated_at TEXT NOT NULL,
+			original_title TEXT NOT NULL,
+			is_adult BOOLEAN NOT NULL,
+			genres TEXT NOT NULL,
+			title_type TEXT NOT NULL,
+			netflix_genres TEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS ` + s.historyTable + ` (
+			title_id TEXT NOT NULL,
+			type TEXT NOT NULL,
+			at TEXT NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS ` + s.historyTable + `_title_id ON ` + s.historyTable + ` (title_id)`,
+	}
+
+	for _, statement := range statements {
+		if _, err := s.db.ExecContext(ctx, statement); err != nil {
+			return fmt.Errorf("failed to create tables: %w", err)
+		}
+	}
+	return nil
+}
+
+func (s *AvailabilityStore) ReadIDs(ctx context.Context) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id FROM `+s.titlesTable+` ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read title IDs: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to read title ID: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// titleColumns are the columns that scanTitle reads.
+const titleColumns = `id, title, year, updated_at, original_title, is_adult, genres, title_type, netflix_genres`
+
+// getManyBatchSize is how many IDs GetMany looks up per query, which keeps
+// it below the limit on query parameters. Tests make it smaller.
+var getManyBatchSize = 500
+
+// Get returns the title with the given ID, or sql.ErrNoRows.
+func (s *AvailabilityStore) Get(ctx context.Context, id string) (*availability.Title, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+titleColumns+` FROM `+s.titlesTable+` WHERE id = $1`, id)
+	_, title, err := scanTitle(row)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get title %s: %w", id, err)
+	}
+	return title, nil
+}
+
+func (s *AvailabilityStore) GetMany(ctx context.Context, ids []string) ([]availability.Record, error) {
+	titles := make(map[string]*availability.Title, len(ids))
+	for start := 0; start < len(ids); start += getManyBatchSize {
+		batch := ids[start:min(start+getManyBatchSize, len(ids))]
+		if err := s.getBatch(ctx, batch, titles); err != nil {
+			return nil, err
+		}
+	}
+
+	// The rows come back in any order, so they are put in the order of ids.
+	var records []availability.Record
+	for _, id := range ids {
+		if title, ok := titles[id]; ok {
+			records = append(records, availability.Record{ID: id, Title: *title})
+		}
+	}
+	return records, nil
+}
+
+// getBatch reads the titles with the given IDs into titles in one query.
+func (s *AvailabilityStore) getBatch(ctx context.Context, ids []string, titles map[string]*availability.Title) error {
+	placeholders := make([]string, len(ids))
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		placeholders[i] = "$" + strconv.Itoa(i+1)
+		args[i] = id
+	}
+
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+titleColumns+` FROM `+s.titlesTable+` WHERE id IN (`+strings.Join(placeholders, ", ")+`)`, args...,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to get titles: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		id, title, err := scanTitle(rows)
+		if err != nil {
+			return fmt.Errorf("failed to get titles: %w", err)
+		}
+		titles[id] = title
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to get titles: %w", err)
+	}
+	return nil
+}
+
+// scanTitle reads a row of titleColumns.
+func scanTitle(row interface{ Scan(dest ...any) error }) (string, *availability.Title, error) {
+	var (
+		id                            string
+		title                         availability.Title
+		updatedAt                     string
+		genresJSON, netflixGenresJSON string
+	)
+
+	err := row.Scan(&id, &title.Title, &title.Year, &updatedAt, &title.OriginalTitle, &title.IsAdult, &genresJSON, &title.TitleType, &netflixGenresJSON)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if title.UpdatedAt, err = time.Parse(timestampLayout, updatedAt); err != nil {
+		return "", nil, fmt.Errorf("failed to parse updated_at of title %s: %w", id, err)
+	}
+	if err := json.Unmarshal([]byte(genresJSON), &title.Genres); err != nil {
+		return "", nil, fmt.Errorf("failed to parse genres of title %s: %w", id, err)
+	}
+	if err := json.Unmarshal([]byte(netflixGenresJSON), &title.NetflixGenres); err != nil {
+		return "", nil, fmt.Errorf("failed to parse netflix genres of title %s: %w", id, err)
+	}
+
+	return id, &title, nil
+}
+
+func (s *AvailabilityStore) Upsert(ctx context.Context, records []availability.Record) error {
+	return s.inTx(ctx, `INSERT INTO `+s.titlesTable+`
+		(id, title, year, updated_at, original_title, is_adult, genres, title_type, netflix_genres)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (id) DO UPDATE SET
+			title = excluded.title,
+			year = excluded.year,
+			updated_at = excluded.updated_at,
+			original_title = excluded.original_title,
+			is_adult = excluded.is_adult,
+			genres = excluded.genres,
+			title_type = excluded.title_type,
+			netflix_genres = excluded.netflix_genres`,
+		len(records), func(stmt *sql.Stmt, i int) error {
+			record := records[i]
+			genres, err := json.Marshal(nonNil(record.Title.Genres))
+			if err != nil {
+				return err
+			}
+			netflixGenres, err := json.Marshal(nonNil(record.Title.NetflixGenres))
+			if err != nil {
+				return err
+			}
+
+			_, err = stmt.ExecContext(ctx,
+				record.ID,
+				record.Title.Title,
+				record.Title.Year,
+				record.Title.UpdatedAt.UTC().Format(timestampLayout),
+				record.Title.OriginalTitle,
+				record.Title.IsAdult,
+				string(genres),
+				record.Title.TitleType,
+				string(netflixGenres),
+			)
+			if err != nil {
+				return fmt.Errorf("failed to upsert title %s: %w", record.ID, err)
+			}
+			return nil
+		})
+}
+
+func (s *AvailabilityStore) Delete(ctx context.Context, ids []string) error {
+	return s.inTx(ctx, `DELETE FROM `+s.titlesTable+` WHERE id = $1`, len(ids), func(stmt *sql.Stmt, i int) error {
+		if _, err := stmt.ExecContext(ctx, ids[i]); err != nil {
+			return fmt.Errorf("failed to delete title %s: %w", ids[i], err)
+		}
+		return nil
+	})
+}
+
+func (s *AvailabilityStore) AppendHistory(ctx context.Context, events []availability.Event) error {
+	return s.inTx(ctx, `INSERT INTO `+s.historyTable+` (title_id, type, at) VALUES ($1, $2, $3)`, len(events), func(stmt *sql.Stmt, i int) error {
+		event := events[i]
+		if _, err := stmt.ExecContext(ctx, event.TitleID, string(event.Type), event.At.UTC().Format(timestampLayout)); err != nil {
+			return fmt.Errorf("failed to append history of title %s: %w", event.TitleID, err)
+		}
+		return nil
+	})
+}
+
+func (s *AvailabilityStore) History(ctx context.Context, titleID string) ([]availability.Event, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT type, at FROM `+s.historyTable+` WHERE title_id = $1 ORDER BY at`, titleID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history: %w", err)
+	}
+	defer rows.Close()
+
+	var events []availability.Event
+	for rows.Next() {
+		var eventType, at string
+		if err := rows.Scan(&eventType, &at); err != nil {
+			return nil, fmt.Errorf("failed to read history: %w", err)
+		}
+
+		event := availability.Event{
+			TitleID: titleID,
+			Type:    availability.EventType(eventType),
+		}
+		if event.At, err = time.Parse(timestampLayout, at); err != nil {
+			return nil, fmt.Errorf("failed to parse history time: %w", err)
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+func (s *AvailabilityStore) Close() error {
+	return s.db.Close()
+}
+
+// inTx runs the statement n times in one transaction, with exec binding the
+// arguments of the i-th run.
+func (s *AvailabilityStore) inTx(ctx context.Context, query string, n int, exec func(stmt *sql.Stmt, i int) error) error {
+	if n == 0 {
+		return nil
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to prepare statement: %w", err)
+	}
+	defer stmt.Close()
+
+	for i := 0; i < n; i++ {
+		if err := exec(stmt, i); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+func nonNil(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
@@ -0,0 +1,127 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/jonwilberg/stream-finder/internal/availability"
+)
+
+func openTestStore(t *testing.T) *AvailabilityStore {
+	t.Helper()
+	store, err := Open(context.Background(), "sqlite", filepath.Join(t.TempDir(), "availability.db"), "netflix")
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestOpenInvalidProvider(t *testing.T) {
+	_, err := Open(context.Background(), "sqlite", filepath.Join(t.TempDir(), "availability.db"), "netflix; DROP TABLE x")
+	if err == nil {
+		t.Errorf("Open() with invalid provider should fail")
+	}
+}
+
+func TestUpsertAndGet(t *testing.T) {
+	ctx := context.Background()
+	store := openTestStore(t)
+
+	updatedAt := time.Date(2024, 5, 1, 12, 30, 0, 123, time.FixedZone("CEST", 2*60*60))
+	want := availability.Title{
+		Title:         "The Irishman",
+		Year:          2019,
+		UpdatedAt:     updatedAt,
+		NetflixGenres: []string{"5824", "34399"},
+	}
+
+	if err := store.Upsert(ctx, []availability.Record{{ID: "Video:80175798", Title: want}}); err != nil {
+		t.Fatalf("Upsert() error = %v", err)
+	}
+	want.Year = 2020
+	if err := store.Upsert(ctx, []availability.Record{{ID: "Video:80175798", Title: want}}); err != nil {
+		t.Fatalf("Upsert() error = %v", err)
+	}
+
+	got, err := store.Get(ctx, "Video:80175798")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if got.Title != want.Title || got.Year != want.Year || !got.UpdatedAt.Equal(updatedAt) || !slices.Equal(got.NetflixGenres, want.NetflixGenres) {
+		t.Errorf("Get() = %+v, want %+v", got, want)
+	}
+
+	if err := store.Delete(ctx, []string{"Video:80175798"}); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := store.Get(ctx, "Video:80175798"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Get() after Delete() error = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func TestGetMany(t *testing.T) {
+	defer func(size int) { getManyBatchSize = size }(getManyBatchSize)
+	getManyBatchSize = 2
+
+	ctx := context.Background()
+	store := openTestStore(t)
+
+	var records []availability.Record
+	for _, id := range []string{"Video:1", "Video:2", "Video:3", "Video:4", "Video:5"} {
+		records = append(records, availability.Record{ID: id, Title: availability.Title{Title: "Title " + id, UpdatedAt: time.Now()}})
+	}
+	if err := store.Upsert(ctx, records); err != nil {
+		t.Fatalf("Upsert() error = %v", err)
+	}
+
+	got, err := store.GetMany(ctx, []string{"Video:4", "Video:missing", "Video:1", "Video:5", "Video:2"})
+	if err != nil {
+		t.Fatalf("GetMany() error = %v", err)
+	}
+	var ids []string
+	for _, record := range got {
+		ids = append(ids, record.ID)
+		if record.Title.Title != "Title "+record.ID {
+			t.Errorf("GetMany() record %s = %+v, want its own title", record.ID, record.Title)
+		}
+	}
+	if want := []string{"Video:4", "Video:1", "Video:5", "Video:2"}; !slices.Equal(ids, want) {
+		t.Errorf("GetMany() IDs = %v, want %v", ids, want)
+	}
+
+	if got, err := store.GetMany(ctx, nil); err != nil || len(got) != 0 {
+		t.Errorf("GetMany() of no IDs = %v, %v, want none", got, err)
+	}
+}
+
+func TestHistoryOrder(t *testing.T) {
+	ctx := context.Background()
+	store := openTestStore(t)
+
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	events := []availability.Event{
+		{TitleID: "Video:1", Type: availability.EventRemoved, At: base.Add(48 * time.Hour)},
+		{TitleID: "Video:2", Type: availability.EventAdded, At: base},
+		{TitleID: "Video:1", Type: availability.EventAdded, At: base.Add(time.Nanosecond)},
+	}
+	if err := store.AppendHistory(ctx, events); err != nil {
+		t.Fatalf("AppendHistory() error = %v", err)
+	}
+
+	history, err := store.History(ctx, "Video:1")
+	if err != nil {
+		t.Fatalf("History() error = %v", err)
+	}
+	if len(history) != 2 {
+		t.Fatalf("History() returned %d events, want 2", len(history))
+	}
+	if history[0].Type != availability.EventAdded || history[1].Type != availability.EventRemoved {
+		t.Errorf("History() = %+v, want added then removed", history)
+	}
+}
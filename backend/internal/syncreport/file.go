@@ -0,0 +1,101 @@
+package syncreport
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+)
+
+// FileStore appends reports to a JSON Lines file, one report per line.
+type FileStore struct {
+	mu   sync.Mutex
+	path string
+}
+
+func NewFileStore(path string) *FileStore {
+	return &FileStore{path: path}
+}
+
+func (s *FileStore) Save(ctx context.Context, report *Report) error {
+	data, err := json.Marshal(report)
+	if err != nil {
+		return fmt.Errorf("failed to encode sync report: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open sync report file: %w", err)
+	}
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write sync report: %w", err)
+	}
+	return file.Close()
+}
+
+func (s *FileStore) List(ctx context.Context, limit int) ([]Report, error) {
+	if err := ValidateLimit(limit); err != nil {
+		return nil, err
+	}
+	reports, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	slices.Reverse(reports)
+	return reports[:min(len(reports), limit)], nil
+}
+
+func (s *FileStore) Get(ctx context.Context, id string) (*Report, error) {
+	reports, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	for i := len(reports) - 1; i >= 0; i-- {
+		if reports[i].ID == id {
+			return &reports[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%s: %w", id, ErrNotFound)
+}
+
+// read returns the reports in the order they were saved.
+func (s *FileStore) read() ([]Report, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, err := os.Open(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sync report file: %w", err)
+	}
+	defer file.Close()
+
+	var reports []Report
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		var report Report
+		if err := json.Unmarshal(scanner.Bytes(), &report); err != nil {
+			return nil, fmt.Errorf("failed to decode sync report on line %d: %w", line, err)
+		}
+		reports = append(reports, report)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read sync report file: %w", err)
+	}
+	return reports, nil
+}
+
+func (s *FileStore) Close() error {
+	return nil
+}
@@ -0,0 +1,55 @@
+package syncreport
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewFileStore(filepath.Join(t.TempDir(), "sync_runs.jsonl"))
+
+	reports, err := store.List(ctx, 10)
+	if err != nil || len(reports) != 0 {
+		t.Fatalf("List() of a new store = %v, %v, want no reports", reports, err)
+	}
+
+	started := time.Date(2024, 3, 1, 4, 0, 0, 0, time.UTC)
+	for i := range 3 {
+		report := New(started.Add(time.Duration(i) * time.Hour))
+		report.Stages = []Stage{{Name: "FetchNetflixTitles", StartedAt: report.StartedAt, DurationSeconds: 61.5}}
+		report.Finish(report.StartedAt.Add(time.Minute), nil)
+		if err := store.Save(ctx, report); err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+	}
+
+	reports, err = store.List(ctx, 2)
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(reports) != 2 || reports[0].ID != "20240301T060000.000Z" || reports[1].ID != "20240301T050000.000Z" {
+		t.Errorf("List() = %+v, want the two most recent reports", reports)
+	}
+
+	report, err := store.Get(ctx, "20240301T040000.000Z")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if report.Status != StatusSucceeded || len(report.Stages) != 1 || report.Stages[0].DurationSeconds != 61.5 {
+		t.Errorf("Get() = %+v", report)
+	}
+
+	if _, err := store.Get(ctx, "20200101T000000.000Z"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() of an unknown report error = %v, want ErrNotFound", err)
+	}
+
+	for _, limit := range []int{0, -1} {
+		if reports, err := store.List(ctx, limit); !errors.Is(err, ErrInvalidLimit) {
+			t.Errorf("List(%d) = %v, %v, want ErrInvalidLimit", limit, reports, err)
+		}
+	}
+}
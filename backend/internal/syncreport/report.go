@@ -0,0 +1,108 @@
+// Package syncreport records what each sync run did, so that runs can be
+// compared and failures looked into after the logs are gone.
+package syncreport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrNotFound = errors.New("sync report not found")
+
+// ErrInvalidLimit is the error of a List call with a limit below 1.
+var ErrInvalidLimit = errors.New("limit must be at least 1")
+
+type Status string
+
+const (
+	StatusRunning   Status = "running"
+	StatusSucceeded Status = "succeeded"
+	StatusFailed    Status = "failed"
+)
+
+// Report is the outcome of one sync run.
+type Report struct {
+	ID         string    `json:"id" firestore:"id"`
+	StartedAt  time.Time `json:"started_at" firestore:"started_at"`
+	FinishedAt time.Time `json:"finished_at" firestore:"finished_at"`
+	Status     Status    `json:"status" firestore:"status"`
+	// Error is the error the run failed with.
+	Error string `json:"error,omitempty" firestore:"error,omitempty"`
+	// Country is the Netflix country the catalog was crawled for.
+	Country  string    `json:"country,omitempty" firestore:"country,omitempty"`
+	Stages   []Stage   `json:"stages" firestore:"stages"`
+	Datasets []Dataset `json:"datasets" firestore:"datasets"`
+
+	Netflix       Counts `json:"netflix" firestore:"netflix"`
+	IMDb          Counts `json:"imdb" firestore:"imdb"`
+	Elasticsearch Counts `json:"elasticsearch" firestore:"elasticsearch"`
+}
+
+// Stage is the timing of one stage of a sync run.
+type Stage struct {
+	Name            string    `json:"name" firestore:"name"`
+	StartedAt       time.Time `json:"started_at" firestore:"started_at"`
+	DurationSeconds float64   `json:"duration_seconds" firestore:"duration_seconds"`
+	Error           string    `json:"error,omitempty" firestore:"error,omitempty"`
+}
+
+// Counts counts what a sync run did with the titles of one source or store.
+// Counts that do not apply to it are left at zero.
+type Counts struct {
+	Fetched int `json:"fetched" firestore:"fetched"`
+	Added   int `json:"added" firestore:"added"`
+	Updated int `json:"updated" firestore:"updated"`
+	Removed int `json:"removed" firestore:"removed"`
+	Failed  int `json:"failed" firestore:"failed"`
+	// Filtered counts the titles dropped by the IMDb ingest filter.
+	Filtered int `json:"filtered,omitempty" firestore:"filtered,omitempty"`
+}
+
+// Dataset identifies the version of a source dataset a run read.
+type Dataset struct {
+	Source       string    `json:"source" firestore:"source"`
+	URL          string    `json:"url" firestore:"url"`
+	ETag         string    `json:"etag,omitempty" firestore:"etag,omitempty"`
+	LastModified time.Time `json:"last_modified,omitempty" firestore:"last_modified,omitempty"`
+}
+
+// New starts the report of a run that starts now.
+func New(startedAt time.Time) *Report {
+	startedAt = startedAt.UTC()
+	return &Report{
+		ID:        startedAt.Format("20060102T150405.000Z"),
+		StartedAt: startedAt,
+		Status:    StatusRunning,
+	}
+}
+
+// Finish records the end of the run and the error it failed with, if any.
+func (r *Report) Finish(finishedAt time.Time, err error) {
+	r.FinishedAt = finishedAt.UTC()
+	r.Status = StatusSucceeded
+	if err != nil {
+		r.Status = StatusFailed
+		r.Error = err.Error()
+	}
+}
+
+// Store keeps the reports of past runs.
+type Store interface {
+	Save(ctx context.Context, report *Report) error
+	// List returns up to limit reports, most recent first. A limit below 1
+	// is an error wrapping ErrInvalidLimit, see ValidateLimit.
+	List(ctx context.Context, limit int) ([]Report, error)
+	// Get returns the report with the given ID, or ErrNotFound.
+	Get(ctx context.Context, id string) (*Report, error)
+	Close() error
+}
+
+// ValidateLimit checks the limit passed to Store.List.
+func ValidateLimit(limit int) error {
+	if limit < 1 {
+		return fmt.Errorf("%w: got %d", ErrInvalidLimit, limit)
+	}
+	return nil
+}
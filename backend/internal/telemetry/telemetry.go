@@ -0,0 +1,128 @@
+// Package telemetry sets up OpenTelemetry tracing and metrics. Instrumented
+// packages use the global providers through otel.Tracer and otel.Meter, which
+// do nothing until Setup is called with an exporter.
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdoutmetric"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterOTLP   = "otlp"
+)
+
+// Config selects where traces and metrics are exported. The OTLP endpoint,
+// headers and protocol options are read by the exporter from the standard
+// OTEL_EXPORTER_OTLP_* environment variables.
+type Config struct {
+	Exporter    string `config:"exporter" env:"STREAM_FINDER_TELEMETRY_EXPORTER" usage:"where traces and metrics are exported: none, stdout or otlp"`
+	ServiceName string `config:"service_name" env:"OTEL_SERVICE_NAME" usage:"service name reported with traces and metrics"`
+}
+
+func (c Config) Validate() error {
+	switch c.Exporter {
+	case "", ExporterNone, ExporterStdout, ExporterOTLP:
+		return nil
+	default:
+		return fmt.Errorf("unknown exporter %q", c.Exporter)
+	}
+}
+
+// Setup installs the global tracer and meter providers. The returned function
+// flushes and stops them, and must be called before the program exits.
+func Setup(ctx context.Context, config Config) (func(context.Context) error, error) {
+	if config.Exporter == "" || config.Exporter == ExporterNone {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	spanExporter, metricExporter, err := newExporters(ctx, config.Exporter)
+	if err != nil {
+		return nil, err
+	}
+
+	serviceName := config.ServiceName
+	if serviceName == "" {
+		serviceName = "stream-finder"
+	}
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName(serviceName)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create telemetry resource: %w", err)
+	}
+
+	tracerProvider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(spanExporter),
+		sdktrace.WithResource(res),
+	)
+	meterProvider := sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(metricExporter)),
+		sdkmetric.WithResource(res),
+	)
+
+	otel.SetTracerProvider(tracerProvider)
+	otel.SetMeterProvider(meterProvider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	return func(ctx context.Context) error {
+		return errors.Join(tracerProvider.Shutdown(ctx), meterProvider.Shutdown(ctx))
+	}, nil
+}
+
+func newExporters(ctx context.Context, exporter string) (sdktrace.SpanExporter, sdkmetric.Exporter, error) {
+	switch exporter {
+	case ExporterStdout:
+		// Standard output carries the progress bars and printed config.
+		spanExporter, err := stdouttrace.New(stdouttrace.WithWriter(os.Stderr))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create stdout trace exporter: %w", err)
+		}
+		metricExporter, err := stdoutmetric.New(stdoutmetric.WithWriter(os.Stderr))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create stdout metric exporter: %w", err)
+		}
+		return spanExporter, metricExporter, nil
+	case ExporterOTLP:
+		spanExporter, err := otlptracehttp.New(ctx)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create OTLP trace exporter: %w", err)
+		}
+		metricExporter, err := otlpmetrichttp.New(ctx)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create OTLP metric exporter: %w", err)
+		}
+		return spanExporter, metricExporter, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown telemetry exporter %q", exporter)
+	}
+}
+
+// End records err on the span, if there is one, and ends the span. It is
+// meant to be deferred with a named error result.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
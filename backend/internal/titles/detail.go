@@ -0,0 +1,95 @@
+package titles
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jonwilberg/stream-finder/internal/availability"
+	"github.com/jonwilberg/stream-finder/internal/repos/elasticsearch"
+)
+
+// TitleDetail is an IMDb title together with where it can be watched.
+type TitleDetail struct {
+	ID            string         `json:"id"`
+	TitleType     string         `json:"title_type"`
+	Title         string         `json:"title"`
+	OriginalTitle string         `json:"original_title"`
+	IsAdult       bool           `json:"is_adult"`
+	Year          int            `json:"year"`
+	Genres        []string       `json:"genres"`
+	Availability  []Availability `json:"availability"`
+}
+
+// Availability is a title as it is listed on a streaming provider.
+type Availability struct {
+	Provider  string              `json:"provider"`
+	ID        string              `json:"id"`
+	URL       string              `json:"url"`
+	Title     string              `json:"title"`
+	Year      int                 `json:"year"`
+	Genres    []string            `json:"genres"`
+	UpdatedAt time.Time           `json:"updated_at"`
+	History   []AvailabilityEvent `json:"history"`
+}
+
+type AvailabilityEvent struct {
+	Type availability.EventType `json:"type"`
+	At   time.Time              `json:"at"`
+}
+
+// GetTitleDetail joins the IMDb title with the Netflix availability records it
+// was matched to. Matches that are no longer on Netflix are left out. The
+// error wraps elasticsearch.ErrNotFound if there is no such title.
+func GetTitleDetail(ctx context.Context, elasticsearchRepo *elasticsearch.Repository, netflixStore availability.Store, id string) (*TitleDetail, error) {
+	document, err := elasticsearchRepo.GetTitle(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := netflixStore.GetMany(ctx, document.Body.NetflixIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get netflix availability: %w", err)
+	}
+
+	detail := &TitleDetail{
+		ID:            document.ID,
+		TitleType:     document.Body.TitleType,
+		Title:         document.Body.Title,
+		OriginalTitle: document.Body.OriginalTitle,
+		IsAdult:       document.Body.IsAdult,
+		Year:          document.Body.Year,
+		Genres:        document.Body.Genres,
+		Availability:  make([]Availability, 0, len(records)),
+	}
+
+	for _, record := range records {
+		events, err := netflixStore.History(ctx, record.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get history of %s: %w", record.ID, err)
+		}
+
+		history := make([]AvailabilityEvent, 0, len(events))
+		for _, event := range events {
+			history = append(history, AvailabilityEvent{Type: event.Type, At: event.At})
+		}
+
+		detail.Availability = append(detail.Availability, Availability{
+			Provider:  "netflix",
+			ID:        record.ID,
+			URL:       netflixURL(record.ID),
+			Title:     record.Title.Title,
+			Year:      record.Title.Year,
+			Genres:    record.Title.NetflixGenres,
+			UpdatedAt: record.Title.UpdatedAt,
+			History:   history,
+		})
+	}
+
+	return detail, nil
+}
+
+func netflixURL(id string) string {
+	return "https://www.netflix.com/title/" + strings.TrimPrefix(id, "Video:")
+}
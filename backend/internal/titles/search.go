@@ -4,16 +4,43 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"time"
 
+	"github.com/jonwilberg/stream-finder/internal/metrics"
 	"github.com/jonwilberg/stream-finder/internal/repos/elasticsearch"
 )
 
-func SearchTitles(ctx context.Context, elasticsearchRepo *elasticsearch.Repository, query string, limit int) ([]elasticsearch.TitleDocument, error) {
+// SearchTitles finds titles matching the query. If netflixGenre is set, only
+// titles listed under that Netflix genre ID are returned.
+func SearchTitles(ctx context.Context, elasticsearchRepo *elasticsearch.Repository, query string, netflixGenre string, limit int) (results []elasticsearch.TitleDocument, err error) {
+	start := time.Now()
+	defer func() {
+		outcome := "succeeded"
+		if err != nil {
+			outcome = "failed"
+		}
+		metrics.SearchDuration.WithLabelValues(outcome).Observe(time.Since(start).Seconds())
+	}()
+
+	filters := []map[string]any{}
+	if netflixGenre != "" {
+		filters = append(filters, map[string]any{
+			"term": map[string]any{
+				"netflix_genres": netflixGenre,
+			},
+		})
+	}
+
 	searchQuery := map[string]any{
 		"size": limit,
 		"query": map[string]any{
-			"match_phrase": map[string]any{
-				"title": query,
+			"bool": map[string]any{
+				"must": map[string]any{
+					"match_phrase": map[string]any{
+						"title": query,
+					},
+				},
+				"filter": filters,
 			},
 		},
 		"sort": []map[string]any{
@@ -31,7 +58,7 @@ func SearchTitles(ctx context.Context, elasticsearchRepo *elasticsearch.Reposito
 		return nil, fmt.Errorf("failed to unmarshal search response: %w", err)
 	}
 
-	results := make([]elasticsearch.TitleDocument, 0, len(response.Hits.Hits))
+	results = make([]elasticsearch.TitleDocument, 0, len(response.Hits.Hits))
 	for _, hit := range response.Hits.Hits {
 		results = append(results, elasticsearch.TitleDocument{
 			ID:   hit.ID,
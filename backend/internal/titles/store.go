@@ -0,0 +1,61 @@
+package titles
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jonwilberg/stream-finder/internal/availability"
+	firestore_repo "github.com/jonwilberg/stream-finder/internal/repos/firestore"
+	"github.com/jonwilberg/stream-finder/internal/repos/sqldb"
+	"github.com/jonwilberg/stream-finder/internal/syncreport"
+)
+
+// OpenStore opens the availability store of a provider from a spec, which is
+// one of:
+//
+//	firestore             Firestore, as configured (the default)
+//	memory                in memory, for local runs
+//	sqlite:<path>         a SQLite database file
+//	postgres://...        a Postgres connection URL
+func OpenStore(ctx context.Context, spec string, firestoreConfig firestore_repo.Config, provider string) (availability.Store, error) {
+	switch {
+	case spec == "" || spec == "firestore":
+		client, err := firestore_repo.NewFirestoreClient(ctx, firestoreConfig)
+		if err != nil {
+			return nil, err
+		}
+		return firestore_repo.NewAvailabilityStore(client, provider), nil
+	case spec == "memory":
+		return availability.NewMemoryStore(), nil
+	case strings.HasPrefix(spec, "sqlite:"):
+		return sqldb.Open(ctx, "sqlite", strings.TrimPrefix(spec, "sqlite:"), provider)
+	case strings.HasPrefix(spec, "postgres://"), strings.HasPrefix(spec, "postgresql://"):
+		return sqldb.Open(ctx, "pgx", spec, provider)
+	default:
+		return nil, fmt.Errorf("unknown availability store %q", spec)
+	}
+}
+
+// OpenReportStore opens the store of sync run reports from a spec, which is
+// one of:
+//
+//	firestore             the sync_runs collection in Firestore (the default)
+//	file:<path>           a JSON Lines file
+//	none                  reports are not kept, and the store is nil
+func OpenReportStore(ctx context.Context, spec string, firestoreConfig firestore_repo.Config) (syncreport.Store, error) {
+	switch {
+	case spec == "" || spec == "firestore":
+		client, err := firestore_repo.NewFirestoreClient(ctx, firestoreConfig)
+		if err != nil {
+			return nil, err
+		}
+		return firestore_repo.NewReportStore(client), nil
+	case strings.HasPrefix(spec, "file:"):
+		return syncreport.NewFileStore(strings.TrimPrefix(spec, "file:")), nil
+	case spec == "none":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown sync report store %q", spec)
+	}
+}
@@ -4,84 +4,327 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"strings"
 	"time"
 
-	"cloud.google.com/go/firestore"
+	"github.com/jonwilberg/stream-finder/internal/availability"
+	"github.com/jonwilberg/stream-finder/internal/config"
+	"github.com/jonwilberg/stream-finder/internal/metrics"
 	"github.com/jonwilberg/stream-finder/internal/repos/elasticsearch"
-	firestore_repo "github.com/jonwilberg/stream-finder/internal/repos/firestore"
 	"github.com/jonwilberg/stream-finder/internal/repos/imdb"
 	"github.com/jonwilberg/stream-finder/internal/repos/netflix"
+	"github.com/jonwilberg/stream-finder/internal/syncreport"
+	"github.com/jonwilberg/stream-finder/internal/telemetry"
+	"github.com/jonwilberg/stream-finder/pkg/datatools"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
 )
 
-type Title struct {
-	Title         string    `firestore:"title"`
-	Year          int       `firestore:"year"`
-	UpdatedAt     time.Time `firestore:"updated_at"`
-	OriginalTitle string    `firestore:"original_title"`
-	IsAdult       bool      `firestore:"is_adult"`
-	Genres        []string  `firestore:"genres"`
-	TitleType     string    `firestore:"title_type"`
+type Title = availability.Title
+
+type SyncReport = syncreport.Report
+
+var (
+	tracer = otel.Tracer("github.com/jonwilberg/stream-finder/internal/titles")
+	meter  = otel.Meter("github.com/jonwilberg/stream-finder/internal/titles")
+
+	stageDuration, _ = meter.Float64Histogram("stream_finder.sync.stage.duration",
+		metric.WithDescription("Duration of the stages of a sync run"),
+		metric.WithUnit("s"),
+	)
+)
+
+// runStage runs one stage of a sync run in its own span, records how long it
+// took and adds its timing to the report. fn can add attributes, such as
+// counts, to the span.
+func runStage(ctx context.Context, report *SyncReport, name string, fn func(ctx context.Context, span trace.Span) error) (err error) {
+	ctx, span := tracer.Start(ctx, name)
+	defer func() { telemetry.End(span, err) }()
+
+	start := time.Now()
+	err = fn(ctx, span)
+	elapsed := time.Since(start).Seconds()
+
+	stage := syncreport.Stage{Name: name, StartedAt: start.UTC(), DurationSeconds: elapsed}
+	outcome := "succeeded"
+	if err != nil {
+		outcome = "failed"
+		stage.Error = err.Error()
+	}
+	report.Stages = append(report.Stages, stage)
+
+	stageDuration.Record(ctx, elapsed, metric.WithAttributes(
+		attribute.String("stage", name),
+		attribute.String("outcome", outcome),
+	))
+	metrics.SyncStageDuration.WithLabelValues(name, outcome).Observe(elapsed)
+	return err
 }
 
-func UpdateTitles(ctx context.Context) error {
-	elasticsearchClient, err := elasticsearch.NewClient()
+// UpdateTitles runs a sync. The report of the run is returned and saved to
+// the configured report store, also if the run fails.
+func UpdateTitles(ctx context.Context, cfg config.Config) (report *SyncReport, err error) {
+	ctx, span := tracer.Start(ctx, "UpdateTitles", trace.WithAttributes(
+		attribute.String("sync.store", cfg.Sync.Store),
+		attribute.Bool("sync.prune_dry_run", cfg.Sync.PruneDryRun),
+	))
+	defer func() { telemetry.End(span, err) }()
+
+	report = syncreport.New(time.Now())
+	span.SetAttributes(attribute.String("sync.report_id", report.ID))
+
+	reports, err := OpenReportStore(ctx, cfg.Sync.ReportStore(), cfg.Firestore)
 	if err != nil {
-		return fmt.Errorf("failed to create elasticsearch client: %w", err)
+		return report, fmt.Errorf("failed to open sync report store: %w", err)
 	}
+	defer func() {
+		report.Finish(time.Now(), err)
+		slog.Info("Finished sync run", "id", report.ID, "status", report.Status)
+		if reports == nil {
+			return
+		}
+		// A failed save is logged rather than failing the run it reports on.
+		if saveErr := reports.Save(context.WithoutCancel(ctx), report); saveErr != nil {
+			slog.Error("Failed to save sync report", "id", report.ID, "error", saveErr)
+		}
+		reports.Close()
+	}()
+
+	netflixRepo := netflix.NewNetflixRepository(cfg.Netflix)
+	session, err := netflixRepo.Validate(ctx)
+	if err != nil {
+		return report, fmt.Errorf("failed to validate netflix session: %w", err)
+	}
+	slog.Info("Validated Netflix session", "profile", session.ProfileName, "country", session.Country)
+	report.Country = session.Country
 
-	elasticsearchRepo := elasticsearch.NewRepository(elasticsearchClient)
+	elasticsearchClient, err := elasticsearch.NewClient(cfg.Elasticsearch)
+	if err != nil {
+		return report, fmt.Errorf("failed to create elasticsearch client: %w", err)
+	}
+
+	elasticsearchRepo := elasticsearch.NewRepository(elasticsearchClient, cfg.Bulk)
 	if err := elasticsearchRepo.UpdateIndices(ctx); err != nil {
-		return fmt.Errorf("failed to update elasticsearch indices: %w", err)
+		return report, fmt.Errorf("failed to update elasticsearch indices: %w", err)
 	}
 
-	if err := upsertImdbTitles(ctx, elasticsearchRepo); err != nil {
-		return fmt.Errorf("failed to upsert imdb titles: %w", err)
+	var netflixTitles []netflix.NetflixTitle
+	err = runStage(ctx, report, "FetchNetflixTitles", func(ctx context.Context, span trace.Span) (err error) {
+		netflixTitles, err = FetchNewNetflixTitles(ctx, netflixRepo)
+		span.SetAttributes(attribute.Int("netflix.titles", len(netflixTitles)))
+		report.Netflix.Fetched = len(netflixTitles)
+		return err
+	})
+	if err != nil {
+		return report, err
 	}
 
-	return nil
-}
+	store, err := OpenStore(ctx, cfg.Sync.Store, cfg.Firestore, "netflix")
+	if err != nil {
+		return report, fmt.Errorf("failed to open availability store: %w", err)
+	}
+	defer store.Close()
+
+	err = runStage(ctx, report, "SyncNetflixTitles", func(ctx context.Context, span trace.Span) (err error) {
+		counts, err := SyncNetflixTitles(ctx, store, netflixTitles)
+		report.Netflix.Added, report.Netflix.Updated, report.Netflix.Removed = counts.Added, counts.Updated, counts.Removed
+		return err
+	})
+	if err != nil {
+		return report, fmt.Errorf("failed to sync netflix titles: %w", err)
+	}
 
-func upsertImdbTitles(ctx context.Context, elasticsearchRepo *elasticsearch.Repository) error {
-	imdbRepo := imdb.NewIMDBRepository()
+	generation := time.Now().Unix()
+	span.SetAttributes(attribute.Int64("sync.generation", generation))
+	imdbRepo := imdb.NewIMDBRepository(cfg.IMDb)
+	var indexed elasticsearch.BulkIndexResult
+	err = runStage(ctx, report, "UpsertImdbTitles", func(ctx context.Context, span trace.Span) error {
+		result, err := upsertImdbTitles(ctx, span, imdbRepo, elasticsearchRepo, netflixTitles, generation)
 
-	imdbTitles, err := imdbRepo.GetTitles()
+		dataset := imdbRepo.Dataset()
+		report.IMDb.Fetched, report.IMDb.Failed, report.IMDb.Filtered = dataset.Decoded, dataset.Failed, dataset.Filtered
+		report.Elasticsearch.Added, report.Elasticsearch.Updated = result.Created, result.Updated
+		report.Elasticsearch.Failed = result.Failed
+		indexed = result
+		report.Datasets = append(report.Datasets, syncreport.Dataset{
+			Source:       "imdb",
+			URL:          dataset.URL,
+			ETag:         dataset.ETag,
+			LastModified: dataset.LastModified,
+		})
+		return err
+	})
+	if err != nil {
+		return report, fmt.Errorf("failed to upsert imdb titles: %w", err)
+	}
 
+	err = runStage(ctx, report, "DeleteStaleTitles", func(ctx context.Context, span trace.Span) (err error) {
+		report.Elasticsearch.Removed, err = deleteStaleTitles(ctx, span, elasticsearchRepo, generation, indexed.FailedIDs, cfg.Sync)
+		return err
+	})
 	if err != nil {
-		return fmt.Errorf("failed to fetch imdb titles: %w", err)
+		return report, fmt.Errorf("failed to delete stale titles: %w", err)
 	}
 
+	metrics.SyncLastSuccess.SetToCurrentTime()
+	return report, nil
+}
+
+func upsertImdbTitles(ctx context.Context, span trace.Span, imdbRepo imdb.IMDBRepository, elasticsearchRepo *elasticsearch.Repository, netflixTitles []netflix.NetflixTitle, generation int64) (elasticsearch.BulkIndexResult, error) {
+	imdbTitles, err := imdbRepo.GetTitles(ctx)
+
+	if err != nil {
+		return elasticsearch.BulkIndexResult{}, fmt.Errorf("failed to fetch imdb titles: %w", err)
+	}
+
+	netflixMatches := netflixTitlesByKey(netflixTitles)
+
 	documents := make([]elasticsearch.TitleDocument, 0, len(imdbTitles))
+	matched := 0
 	for _, title := range imdbTitles {
+		match := netflixMatches[titleKey(title.Title, title.Year.Int)]
+		if len(match.ids) > 0 {
+			matched++
+		}
 		documents = append(documents, elasticsearch.TitleDocument{
 			ID: title.ID,
 			Body: elasticsearch.TitleDocumentBody{
-				Title:         title.Title,
-				Year:          title.Year,
-				OriginalTitle: title.OriginalTitle,
-				IsAdult:       title.IsAdult,
-				Genres:        title.Genres,
-				TitleType:     title.TitleType,
+				Title:          title.Title,
+				Year:           title.Year.Int,
+				OriginalTitle:  title.OriginalTitle,
+				IsAdult:        title.IsAdult.Bool,
+				Genres:         title.Genres,
+				TitleType:      title.TitleType,
+				NetflixGenres:  match.genres,
+				NetflixIDs:     match.ids,
+				SyncGeneration: generation,
 			},
 		})
 	}
 
+	span.SetAttributes(
+		attribute.Int("imdb.titles", len(imdbTitles)),
+		attribute.Int("sync.netflix_matches", matched),
+	)
 	slog.Info("Writing new titles to elasticsearch", "count", len(documents))
 	return elasticsearchRepo.BulkIndexTitles(ctx, documents)
 }
 
-func FetchNewNetflixTitles() ([]netflix.NetflixTitle, error) {
-	netflixRepo := netflix.NewNetflixRepository()
-	titles, err := netflixRepo.GetTitles()
+// deleteStaleTitles deletes titles that were not indexed by the sync run of the
+// given generation, i.e. titles that were removed from or merged in IMDb.
+// Titles that the run failed to index still have an older generation, so
+// their IDs are passed as failedIDs to keep them.
+func deleteStaleTitles(ctx context.Context, span trace.Span, elasticsearchRepo *elasticsearch.Repository, generation int64, failedIDs []string, opts config.SyncConfig) (int, error) {
+	if err := elasticsearchRepo.Refresh(ctx, "titles"); err != nil {
+		return 0, err
+	}
+
+	total, err := elasticsearchRepo.Count(ctx, "titles", map[string]any{
+		"query": map[string]any{"match_all": map[string]any{}},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	mustNot := []any{
+		map[string]any{"term": map[string]any{"sync_generation": generation}},
+	}
+	if len(failedIDs) > 0 {
+		mustNot = append(mustNot, map[string]any{"ids": map[string]any{"values": failedIDs}})
+	}
+	staleQuery := map[string]any{
+		"query": map[string]any{
+			"bool": map[string]any{"must_not": mustNot},
+		},
+	}
+
+	stale, err := elasticsearchRepo.Count(ctx, "titles", staleQuery)
+	if err != nil {
+		return 0, err
+	}
+	span.SetAttributes(
+		attribute.Int("sync.total_titles", total),
+		attribute.Int("sync.stale_titles", stale),
+	)
+
+	if stale == 0 {
+		slog.Info("No stale titles found")
+		return 0, nil
+	}
+
+	if opts.PruneDryRun {
+		slog.Info("Dry run, not deleting stale titles", "stale_titles", stale, "total_titles", total)
+		return 0, nil
+	}
+
+	if ratio := float64(stale) / float64(total); ratio > opts.PruneMaxRatio {
+		return 0, fmt.Errorf("refusing to delete %d of %d titles, which is more than the allowed ratio of %g", stale, total, opts.PruneMaxRatio)
+	}
+
+	deleted, err := elasticsearchRepo.DeleteByQuery(ctx, "titles", staleQuery)
+	if err != nil {
+		return 0, err
+	}
+
+	span.SetAttributes(attribute.Int("sync.deleted_titles", deleted))
+	metrics.TitlesDeleted.WithLabelValues("elasticsearch").Add(float64(deleted))
+	slog.Info("Deleted stale titles", "deleted", deleted, "total_titles", total)
+	return deleted, nil
+}
+
+type netflixMatch struct {
+	ids    []string
+	genres []string
+}
+
+// netflixTitlesByKey groups Netflix titles by their title key. Netflix and
+// IMDb share no identifier, so titles are matched on their name and year.
+func netflixTitlesByKey(netflixTitles []netflix.NetflixTitle) map[string]netflixMatch {
+	matches := make(map[string]netflixMatch, len(netflixTitles))
+	for _, title := range netflixTitles {
+		key := titleKey(title.Title, title.Year)
+		match := matches[key]
+		match.ids = datatools.Unique(append(match.ids, title.ID))
+		match.genres = datatools.Unique(append(match.genres, title.Genres...))
+		matches[key] = match
+	}
+	return matches
+}
+
+func titleKey(title string, year int) string {
+	return fmt.Sprintf("%s|%d", strings.ToLower(strings.TrimSpace(title)), year)
+}
+
+func FetchNewNetflixTitles(ctx context.Context, netflixRepo netflix.NetflixRepository) ([]netflix.NetflixTitle, error) {
+	titles, err := netflixRepo.GetTitles(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch titles from Netflix: %w", err)
 	}
 	return titles, nil
 }
 
-func DeleteRemovedTitles(ctx context.Context, client *firestore.Client, newTitles []netflix.NetflixTitle) error {
-	oldTitles, err := firestore_repo.ReadAll(ctx, client, "netflix_titles")
+// SyncNetflixTitles makes the store match the titles crawled from Netflix and
+// records which titles were added and removed. The counts of added, updated
+// and removed titles are returned.
+func SyncNetflixTitles(ctx context.Context, store availability.Store, newTitles []netflix.NetflixTitle) (syncreport.Counts, error) {
+	var counts syncreport.Counts
+	removed, err := DeleteRemovedTitles(ctx, store, newTitles)
+	counts.Removed = removed
+	if err != nil {
+		return counts, err
+	}
+	counts.Added, counts.Updated, err = WriteNewTitles(ctx, store, newTitles)
+	return counts, err
+}
+
+// DeleteRemovedTitles deletes the stored titles that are not in newTitles and
+// returns how many were deleted.
+func DeleteRemovedTitles(ctx context.Context, store availability.Store, newTitles []netflix.NetflixTitle) (int, error) {
+	oldTitleIDs, err := store.ReadIDs(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to read existing titles: %w", err)
+		return 0, fmt.Errorf("failed to read existing titles: %w", err)
 	}
 
 	newTitleIDs := make(map[string]struct{}, len(newTitles))
@@ -89,10 +332,10 @@ func DeleteRemovedTitles(ctx context.Context, client *firestore.Client, newTitle
 		newTitleIDs[title.ID] = struct{}{}
 	}
 
-	removeTitles := make(map[string]struct{}, len(oldTitles))
-	for _, oldTitle := range oldTitles {
-		if _, exists := newTitleIDs[oldTitle.ID]; !exists {
-			removeTitles[oldTitle.ID] = struct{}{}
+	removeTitles := make(map[string]struct{}, len(oldTitleIDs))
+	for _, oldTitleID := range oldTitleIDs {
+		if _, exists := newTitleIDs[oldTitleID]; !exists {
+			removeTitles[oldTitleID] = struct{}{}
 		}
 	}
 
@@ -102,35 +345,79 @@ func DeleteRemovedTitles(ctx context.Context, client *firestore.Client, newTitle
 			removeIDs = append(removeIDs, id)
 		}
 
-		slog.Info("Deleting removed titles from firestore",
+		slog.Info("Deleting removed titles",
 			"new_titles", len(newTitles),
-			"old_titles", len(oldTitles),
+			"old_titles", len(oldTitleIDs),
 			"removed_titles", len(removeTitles),
 		)
 
-		if err := firestore_repo.BulkDelete(ctx, client, "netflix_titles", removeIDs); err != nil {
-			return fmt.Errorf("failed to delete removed titles: %w", err)
+		if err := store.Delete(ctx, removeIDs); err != nil {
+			return 0, fmt.Errorf("failed to delete removed titles: %w", err)
+		}
+
+		metrics.TitlesDeleted.WithLabelValues("availability").Add(float64(len(removeIDs)))
+
+		if err := store.AppendHistory(ctx, newEvents(removeIDs, availability.EventRemoved)); err != nil {
+			return len(removeIDs), fmt.Errorf("failed to record removed titles: %w", err)
 		}
 	} else {
 		slog.Info("No removed titles found")
 	}
 
-	return nil
+	return len(removeTitles), nil
 }
 
-func WriteNewTitles(ctx context.Context, client *firestore.Client, titles []netflix.NetflixTitle) error {
-	documents := make([]firestore_repo.Document, 0, len(titles))
+// WriteNewTitles upserts the titles and returns how many of them were added
+// and how many were already stored.
+func WriteNewTitles(ctx context.Context, store availability.Store, titles []netflix.NetflixTitle) (added int, updated int, err error) {
+	oldTitleIDs, err := store.ReadIDs(ctx)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to read existing titles: %w", err)
+	}
+
+	exists := make(map[string]struct{}, len(oldTitleIDs))
+	for _, id := range oldTitleIDs {
+		exists[id] = struct{}{}
+	}
+
+	records := make([]availability.Record, 0, len(titles))
+	var addedIDs []string
 	for _, title := range titles {
-		documents = append(documents, firestore_repo.Document{
+		records = append(records, availability.Record{
 			ID: title.ID,
-			Data: Title{
-				Title:     title.Title,
-				Year:      title.Year,
-				UpdatedAt: time.Now(),
+			Title: Title{
+				Title:         title.Title,
+				Year:          title.Year,
+				UpdatedAt:     time.Now(),
+				NetflixGenres: title.Genres,
 			},
 		})
+		if _, ok := exists[title.ID]; !ok {
+			addedIDs = append(addedIDs, title.ID)
+		}
+	}
+
+	slog.Info("Writing new titles", "count", len(records), "added", len(addedIDs))
+	if err := store.Upsert(ctx, records); err != nil {
+		return 0, 0, fmt.Errorf("failed to write new titles: %w", err)
 	}
 
-	slog.Info("Writing new titles to firestore", "count", len(documents))
-	return firestore_repo.BulkWrite(ctx, client, "netflix_titles", documents)
+	added, updated = len(addedIDs), len(records)-len(addedIDs)
+	if err := store.AppendHistory(ctx, newEvents(addedIDs, availability.EventAdded)); err != nil {
+		return added, updated, fmt.Errorf("failed to record added titles: %w", err)
+	}
+	return added, updated, nil
+}
+
+func newEvents(titleIDs []string, eventType availability.EventType) []availability.Event {
+	now := time.Now()
+	events := make([]availability.Event, 0, len(titleIDs))
+	for _, id := range titleIDs {
+		events = append(events, availability.Event{
+			TitleID: id,
+			Type:    eventType,
+			At:      now,
+		})
+	}
+	return events
 }
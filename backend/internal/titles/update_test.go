@@ -0,0 +1,344 @@
+package titles
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jonwilberg/stream-finder/internal/availability"
+	"github.com/jonwilberg/stream-finder/internal/config"
+	"github.com/jonwilberg/stream-finder/internal/repos/elasticsearch"
+	firestore_repo "github.com/jonwilberg/stream-finder/internal/repos/firestore"
+	"github.com/jonwilberg/stream-finder/internal/repos/firestore/firestoretest"
+	"github.com/jonwilberg/stream-finder/internal/repos/netflix"
+	"github.com/jonwilberg/stream-finder/internal/repos/sqldb"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var stores = []struct {
+	name string
+	open func(t *testing.T) availability.Store
+}{
+	{
+		name: "memory",
+		open: func(t *testing.T) availability.Store {
+			return availability.NewMemoryStore()
+		},
+	},
+	{
+		name: "sqlite",
+		open: func(t *testing.T) availability.Store {
+			store, err := sqldb.Open(context.Background(), "sqlite", filepath.Join(t.TempDir(), "titles.db"), "netflix")
+			if err != nil {
+				t.Fatalf("Failed to open sqlite store: %v", err)
+			}
+			t.Cleanup(func() { store.Close() })
+			return store
+		},
+	},
+	{
+		name: "firestore",
+		open: func(t *testing.T) availability.Store {
+			return firestore_repo.NewAvailabilityStore(firestoretest.NewClient(t), "netflix")
+		},
+	},
+}
+
+func netflixTitlesRange(start int, end int) []netflix.NetflixTitle {
+	titles := make([]netflix.NetflixTitle, 0, end-start)
+	for i := start; i < end; i++ {
+		titles = append(titles, netflix.NetflixTitle{
+			ID:     fmt.Sprintf("Video:%d", 80000000+i),
+			Title:  fmt.Sprintf("Title %d", i),
+			Year:   2000 + i%25,
+			Genres: []string{"34399"},
+		})
+	}
+	return titles
+}
+
+func titleIDs(titles []netflix.NetflixTitle) []string {
+	ids := make([]string, 0, len(titles))
+	for _, title := range titles {
+		ids = append(ids, title.ID)
+	}
+	return ids
+}
+
+func TestWriteNewTitles(t *testing.T) {
+	for _, tt := range stores {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := tt.open(t)
+
+			titles := netflixTitlesRange(0, 1200)
+			added, updated, err := WriteNewTitles(ctx, store, titles)
+			if err != nil {
+				t.Fatalf("WriteNewTitles() error = %v", err)
+			}
+			if added != len(titles) || updated != 0 {
+				t.Errorf("WriteNewTitles() added %d and updated %d titles, want %d added", added, updated, len(titles))
+			}
+
+			ids, err := store.ReadIDs(ctx)
+			if err != nil {
+				t.Fatalf("ReadIDs() error = %v", err)
+			}
+			if !slices.Equal(ids, titleIDs(titles)) {
+				t.Errorf("got %d titles in store, want %d", len(ids), len(titles))
+			}
+
+			records, err := store.GetMany(ctx, []string{titles[7].ID, "Video:1", titles[3].ID})
+			if err != nil {
+				t.Fatalf("GetMany() error = %v", err)
+			}
+			if len(records) != 2 || records[0].ID != titles[7].ID || records[1].Title.Title != titles[3].Title {
+				t.Errorf("GetMany() = %+v, want titles 7 and 3", records)
+			}
+
+			history, err := store.History(ctx, titles[42].ID)
+			if err != nil {
+				t.Fatalf("History() error = %v", err)
+			}
+			if len(history) != 1 || history[0].Type != availability.EventAdded {
+				t.Errorf("History() = %+v, want one added event", history)
+			}
+		})
+	}
+}
+
+func TestSyncNetflixTitles(t *testing.T) {
+	for _, tt := range stores {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := tt.open(t)
+
+			if _, err := SyncNetflixTitles(ctx, store, netflixTitlesRange(0, 1200)); err != nil {
+				t.Fatalf("SyncNetflixTitles() error = %v", err)
+			}
+
+			newTitles := netflixTitlesRange(500, 1500)
+			counts, err := SyncNetflixTitles(ctx, store, newTitles)
+			if err != nil {
+				t.Fatalf("SyncNetflixTitles() error = %v", err)
+			}
+			if counts.Added != 300 || counts.Updated != 700 || counts.Removed != 500 {
+				t.Errorf("SyncNetflixTitles() = %+v, want 300 added, 700 updated and 500 removed", counts)
+			}
+
+			ids, err := store.ReadIDs(ctx)
+			if err != nil {
+				t.Fatalf("ReadIDs() error = %v", err)
+			}
+			if !slices.Equal(ids, titleIDs(newTitles)) {
+				t.Errorf("got %d titles after sync, want %d", len(ids), len(newTitles))
+			}
+
+			removed := netflixTitlesRange(0, 1)[0].ID
+			history, err := store.History(ctx, removed)
+			if err != nil {
+				t.Fatalf("History() error = %v", err)
+			}
+			if len(history) != 2 || history[0].Type != availability.EventAdded || history[1].Type != availability.EventRemoved {
+				t.Errorf("History(%s) = %+v, want added then removed", removed, history)
+			}
+
+			kept := netflixTitlesRange(600, 601)[0].ID
+			history, err = store.History(ctx, kept)
+			if err != nil {
+				t.Fatalf("History() error = %v", err)
+			}
+			if len(history) != 1 {
+				t.Errorf("History(%s) = %+v, want only the added event", kept, history)
+			}
+		})
+	}
+}
+
+func TestDeleteRemovedTitlesNothingRemoved(t *testing.T) {
+	for _, tt := range stores {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := tt.open(t)
+
+			titles := netflixTitlesRange(0, 10)
+			if _, _, err := WriteNewTitles(ctx, store, titles); err != nil {
+				t.Fatalf("WriteNewTitles() error = %v", err)
+			}
+			if removed, err := DeleteRemovedTitles(ctx, store, titles); err != nil || removed != 0 {
+				t.Fatalf("DeleteRemovedTitles() = %d, %v, want nothing removed", removed, err)
+			}
+
+			ids, err := store.ReadIDs(ctx)
+			if err != nil {
+				t.Fatalf("ReadIDs() error = %v", err)
+			}
+			if len(ids) != len(titles) {
+				t.Errorf("got %d titles, want %d", len(ids), len(titles))
+			}
+		})
+	}
+}
+
+func TestDeleteRemovedTitlesCanceled(t *testing.T) {
+	client := firestoretest.NewClient(t)
+	store := firestore_repo.NewAvailabilityStore(client, "netflix")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := DeleteRemovedTitles(ctx, store, nil); err == nil {
+		t.Errorf("DeleteRemovedTitles() with canceled context should fail")
+	}
+}
+
+// titlesIndex fakes the parts of the Elasticsearch API that a sync run uses
+// on the titles index, keeping the sync generation of each document. Bulk
+// index actions for the IDs in rejected fail with a 400.
+type titlesIndex struct {
+	mu          sync.Mutex
+	generations map[string]int64
+	rejected    map[string]bool
+}
+
+// staleQuery is the subset of the query DSL that deleteStaleTitles uses.
+type staleQuery struct {
+	Query struct {
+		Bool struct {
+			MustNot []struct {
+				Term map[string]int64 `json:"term"`
+				IDs  struct {
+					Values []string `json:"values"`
+				} `json:"ids"`
+			} `json:"must_not"`
+		} `json:"bool"`
+	} `json:"query"`
+}
+
+func (q staleQuery) matches(id string, generation int64) bool {
+	for _, clause := range q.Query.Bool.MustNot {
+		if want, ok := clause.Term["sync_generation"]; ok && generation == want {
+			return false
+		}
+		if slices.Contains(clause.IDs.Values, id) {
+			return false
+		}
+	}
+	return true
+}
+
+func (idx *titlesIndex) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Elastic-Product", "Elasticsearch")
+	w.Header().Set("Content-Type", "application/json")
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/_bulk"):
+		var items []map[string]any
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			var action struct {
+				Index struct {
+					ID string `json:"_id"`
+				} `json:"index"`
+			}
+			json.Unmarshal(scanner.Bytes(), &action)
+			scanner.Scan()
+			var body struct {
+				SyncGeneration int64 `json:"sync_generation"`
+			}
+			json.Unmarshal(scanner.Bytes(), &body)
+
+			id := action.Index.ID
+			item := map[string]any{"_id": id, "status": http.StatusCreated, "result": "created"}
+			switch _, exists := idx.generations[id]; {
+			case idx.rejected[id]:
+				item["status"] = http.StatusBadRequest
+				item["error"] = map[string]any{"type": "mapper_parsing_exception", "reason": "rejected by test"}
+			case exists:
+				item["status"], item["result"] = http.StatusOK, "updated"
+				idx.generations[id] = body.SyncGeneration
+			default:
+				idx.generations[id] = body.SyncGeneration
+			}
+			items = append(items, map[string]any{"index": item})
+		}
+		json.NewEncoder(w).Encode(map[string]any{"errors": true, "items": items})
+	case strings.HasSuffix(r.URL.Path, "/_refresh"):
+		json.NewEncoder(w).Encode(map[string]any{})
+	case strings.HasSuffix(r.URL.Path, "/_count"), strings.HasSuffix(r.URL.Path, "/_delete_by_query"):
+		var query staleQuery
+		json.NewDecoder(r.Body).Decode(&query)
+		matched := 0
+		for id, generation := range idx.generations {
+			if query.matches(id, generation) {
+				matched++
+				if strings.HasSuffix(r.URL.Path, "/_delete_by_query") {
+					delete(idx.generations, id)
+				}
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]any{"count": matched, "deleted": matched})
+	default:
+		http.Error(w, "unexpected request "+r.URL.Path, http.StatusNotFound)
+	}
+}
+
+func TestDeleteStaleTitlesKeepsFailedDocuments(t *testing.T) {
+	ctx := context.Background()
+	index := &titlesIndex{
+		generations: map[string]int64{"tt1": 1, "tt2": 1, "tt3": 1, "tt4": 1, "tt5": 1},
+		rejected:    map[string]bool{"tt3": true},
+	}
+	server := httptest.NewServer(index)
+	defer server.Close()
+
+	client, err := elasticsearch.NewClient(elasticsearch.Config{URL: server.URL, NoAuth: true})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	repo := elasticsearch.NewRepository(client, elasticsearch.BulkConfig{},
+		elasticsearch.WithMaxRetries(0),
+		elasticsearch.WithMaxFailureRatio(0.5),
+		elasticsearch.WithDeadLetterPath(filepath.Join(t.TempDir(), "dead_letter.jsonl")),
+	)
+
+	// tt5 is gone from IMDb, tt6 is new, and tt3 fails to index, which is
+	// under the failure ratio.
+	var docs []elasticsearch.TitleDocument
+	for _, id := range []string{"tt1", "tt2", "tt3", "tt4", "tt6"} {
+		docs = append(docs, elasticsearch.TitleDocument{ID: id, Body: elasticsearch.TitleDocumentBody{SyncGeneration: 2}})
+	}
+	result, err := repo.BulkIndexTitles(ctx, docs)
+	if err != nil || !slices.Equal(result.FailedIDs, []string{"tt3"}) {
+		t.Fatalf("BulkIndexTitles() = %+v, %v, want tt3 to fail", result, err)
+	}
+	if result.Created != 1 || result.Updated != 3 {
+		t.Errorf("BulkIndexTitles() = %+v, want tt6 created and 3 titles updated", result)
+	}
+
+	span := trace.SpanFromContext(ctx)
+	deleted, err := deleteStaleTitles(ctx, span, repo, 2, result.FailedIDs, config.SyncConfig{PruneMaxRatio: 0.5})
+	if err != nil || deleted != 1 {
+		t.Fatalf("deleteStaleTitles() = %d, %v, want 1 deleted", deleted, err)
+	}
+
+	var ids []string
+	for id := range index.generations {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	if want := []string{"tt1", "tt2", "tt3", "tt4", "tt6"}; !slices.Equal(ids, want) {
+		t.Errorf("index holds %v, want %v", ids, want)
+	}
+}
@@ -2,14 +2,112 @@ package logging
 
 import (
 	"fmt"
+	"log/slog"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/schollz/progressbar/v3"
 )
 
-func NewProgressBar(description string, barLength int) *progressbar.ProgressBar {
-	return progressbar.NewOptions(barLength,
+// Progress modes select how NewProgress reports progress.
+const (
+	// ProgressAuto renders a bar if standard output is a terminal and logs
+	// progress events otherwise.
+	ProgressAuto = "auto"
+	// ProgressBar always renders a bar.
+	ProgressBar = "bar"
+	// ProgressLog logs progress events with slog.
+	ProgressLog = "log"
+	// ProgressQuiet reports no progress.
+	ProgressQuiet = "quiet"
+)
+
+const defaultProgressInterval = 30 * time.Second
+
+// Config configures how progress is reported.
+type Config struct {
+	Progress string `config:"progress" env:"STREAM_FINDER_PROGRESS" flag:"progress" usage:"how progress is reported: auto, bar, log or quiet"`
+	// ProgressInterval is how often progress is logged in ProgressLog mode.
+	ProgressInterval time.Duration `config:"progress_interval" env:"STREAM_FINDER_PROGRESS_INTERVAL" flag:"progress-interval" usage:"how often progress is logged when it is not shown as a bar"`
+}
+
+func (c Config) Validate() error {
+	switch c.Progress {
+	case "", ProgressAuto, ProgressBar, ProgressLog, ProgressQuiet:
+	default:
+		return fmt.Errorf("unknown progress mode %q", c.Progress)
+	}
+	if c.ProgressInterval < 0 {
+		return fmt.Errorf("progress_interval must not be negative, got %s", c.ProgressInterval)
+	}
+	return nil
+}
+
+var (
+	progressMu     sync.Mutex
+	progressConfig = Config{Progress: ProgressAuto, ProgressInterval: defaultProgressInterval}
+)
+
+// SetProgressConfig sets how progress is reported by the Progress values
+// created after it.
+func SetProgressConfig(config Config) {
+	if config.Progress == "" {
+		config.Progress = ProgressAuto
+	}
+	if config.ProgressInterval == 0 {
+		config.ProgressInterval = defaultProgressInterval
+	}
+
+	progressMu.Lock()
+	defer progressMu.Unlock()
+	progressConfig = config
+}
+
+// Progress reports the progress of a task.
+type Progress interface {
+	// Add records that n more items are done.
+	Add(n int)
+	// Finish records that the task is done.
+	Finish()
+}
+
+// NewProgress starts reporting the progress of a task with the given total
+// number of items, or -1 if the total is not known.
+func NewProgress(description string, total int) Progress {
+	progressMu.Lock()
+	config := progressConfig
+	progressMu.Unlock()
+
+	mode := config.Progress
+	if mode == ProgressAuto {
+		mode = ProgressLog
+		if isTerminal(os.Stdout) {
+			mode = ProgressBar
+		}
+	}
+
+	switch mode {
+	case ProgressBar:
+		return newProgressBar(description, total)
+	case ProgressQuiet:
+		return quietProgress{}
+	default:
+		return newLogProgress(description, total, config.ProgressInterval)
+	}
+}
+
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+type progressBar struct {
+	bar *progressbar.ProgressBar
+}
+
+func newProgressBar(description string, total int) *progressBar {
+	return &progressBar{bar: progressbar.NewOptions(total,
 		progressbar.OptionSetDescription(description),
 		progressbar.OptionShowCount(),
 		progressbar.OptionSetWidth(15),
@@ -21,5 +119,89 @@ func NewProgressBar(description string, barLength int) *progressbar.ProgressBar
 		progressbar.OptionOnCompletion(func() {
 			fmt.Fprint(os.Stdout, "\n")
 		}),
+	)}
+}
+
+func (p *progressBar) Add(n int) {
+	p.bar.Add(n)
+}
+
+func (p *progressBar) Finish() {
+	p.bar.Finish()
+}
+
+// logProgress logs a progress event with the rate and, if the total is known,
+// the estimated time left, at most once per interval.
+type logProgress struct {
+	mu          sync.Mutex
+	description string
+	total       int
+	done        int
+	interval    time.Duration
+	start       time.Time
+	lastLogged  time.Time
+	now         func() time.Time
+}
+
+func newLogProgress(description string, total int, interval time.Duration) *logProgress {
+	now := time.Now()
+	return &logProgress{
+		description: description,
+		total:       total,
+		interval:    interval,
+		start:       now,
+		lastLogged:  now,
+		now:         time.Now,
+	}
+}
+
+func (p *logProgress) Add(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.done += n
+	now := p.now()
+	if now.Sub(p.lastLogged) < p.interval {
+		return
+	}
+	p.lastLogged = now
+
+	rate := p.rate(now)
+	attrs := []any{"task", p.description, "done", p.done, "rate", fmt.Sprintf("%.1f/s", rate)}
+	if p.total > 0 {
+		attrs = append(attrs, "total", p.total, "percent", fmt.Sprintf("%.1f", 100*float64(p.done)/float64(p.total)))
+		if rate > 0 {
+			eta := time.Duration(float64(p.total-p.done) / rate * float64(time.Second))
+			attrs = append(attrs, "eta", eta.Round(time.Second).String())
+		}
+	}
+	slog.Info("Progress", attrs...)
+}
+
+func (p *logProgress) Finish() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	slog.Info("Finished",
+		"task", p.description,
+		"done", p.done,
+		"elapsed", now.Sub(p.start).Round(time.Millisecond).String(),
+		"rate", fmt.Sprintf("%.1f/s", p.rate(now)),
 	)
 }
+
+// rate returns the items done per second since the start.
+func (p *logProgress) rate(now time.Time) float64 {
+	elapsed := now.Sub(p.start).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(p.done) / elapsed
+}
+
+type quietProgress struct{}
+
+func (quietProgress) Add(int) {}
+
+func (quietProgress) Finish() {}
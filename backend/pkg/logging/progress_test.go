@@ -0,0 +1,61 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestLogProgress(t *testing.T) {
+	var buf bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	now := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	p := newLogProgress("Indexing", 1000, 10*time.Second)
+	p.start, p.lastLogged = now, now
+	p.now = func() time.Time { return now }
+
+	now = now.Add(5 * time.Second)
+	p.Add(100) // within the interval, not logged
+	now = now.Add(5 * time.Second)
+	p.Add(100)
+	now = now.Add(time.Second)
+	p.Add(100) // within the interval, not logged
+	now = now.Add(9 * time.Second)
+	p.Add(100)
+	p.Finish()
+
+	var events []map[string]any
+	decoder := json.NewDecoder(&buf)
+	for decoder.More() {
+		var event map[string]any
+		if err := decoder.Decode(&event); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, event)
+	}
+
+	if len(events) != 3 {
+		t.Fatalf("got %d events, want 2 progress events and 1 finished event: %v", len(events), events)
+	}
+	first := events[0]
+	if first["msg"] != "Progress" || first["done"] != 200.0 || first["rate"] != "20.0/s" || first["percent"] != "20.0" || first["eta"] != "40s" {
+		t.Errorf("first event = %v", first)
+	}
+	if last := events[2]; last["msg"] != "Finished" || last["done"] != 400.0 || last["elapsed"] != "20s" {
+		t.Errorf("finished event = %v", last)
+	}
+}
+
+func TestNewProgressQuiet(t *testing.T) {
+	SetProgressConfig(Config{Progress: ProgressQuiet})
+	t.Cleanup(func() { SetProgressConfig(Config{}) })
+
+	if _, ok := NewProgress("Decoding", -1).(quietProgress); !ok {
+		t.Errorf("NewProgress() in quiet mode should report nothing")
+	}
+}
@@ -0,0 +1,281 @@
+// Package vcr records HTTP interactions to a cassette file and replays them,
+// so that clients talking to external services can be tested offline.
+package vcr
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+type Mode int
+
+const (
+	// ModeReplay serves responses from the cassette and fails requests that
+	// were not recorded.
+	ModeReplay Mode = iota
+	// ModeRecord sends requests to the real transport and records them.
+	ModeRecord
+)
+
+// ModeFromEnv returns ModeRecord if VCR_RECORD is set, so fixtures can be
+// re-recorded without changing the tests.
+func ModeFromEnv() Mode {
+	if os.Getenv("VCR_RECORD") != "" {
+		return ModeRecord
+	}
+	return ModeReplay
+}
+
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+type Interaction struct {
+	Request  Request  `json:"request"`
+	Response Response `json:"response"`
+}
+
+// Request identifies a recorded request. Headers are not recorded, as they
+// carry the session cookies.
+type Request struct {
+	Method string `json:"method"`
+	URL    string `json:"url"`
+	Body   string `json:"body,omitempty"`
+}
+
+// Response is a recorded response. Bodies that are not valid UTF-8 are stored
+// base64 encoded in BinaryBody.
+type Response struct {
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       string      `json:"body,omitempty"`
+	BinaryBody []byte      `json:"binary_body,omitempty"`
+}
+
+// Recorder is an http.RoundTripper that records to or replays from a cassette.
+// Identical requests are replayed in the order they were recorded.
+type Recorder struct {
+	path      string
+	mode      Mode
+	transport http.RoundTripper
+
+	mu       sync.Mutex
+	cassette Cassette
+	used     []bool
+}
+
+// New creates a recorder for the cassette at path. In ModeReplay the cassette
+// must exist, in ModeRecord it is created or replaced when Stop is called.
+// The transport is used in ModeRecord and defaults to http.DefaultTransport.
+func New(path string, mode Mode, transport http.RoundTripper) (*Recorder, error) {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	r := &Recorder{
+		path:      path,
+		mode:      mode,
+		transport: transport,
+	}
+
+	if mode == ModeReplay {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read cassette: %w", err)
+		}
+		if err := json.Unmarshal(data, &r.cassette); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal cassette %s: %w", path, err)
+		}
+		r.used = make([]bool, len(r.cassette.Interactions))
+	}
+
+	return r, nil
+}
+
+// Client returns an http.Client that sends its requests through the recorder.
+func (r *Recorder) Client() *http.Client {
+	return &http.Client{Transport: r}
+}
+
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	recorded, err := newRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.mode == ModeRecord {
+		return r.record(req, recorded)
+	}
+	return r.replay(req, recorded)
+}
+
+func (r *Recorder) record(req *http.Request, recorded Request) (*http.Response, error) {
+	resp, err := r.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	header := resp.Header.Clone()
+	header.Del("Set-Cookie")
+
+	response := Response{
+		StatusCode: resp.StatusCode,
+		Header:     header,
+	}
+	if utf8.Valid(body) {
+		response.Body = string(body)
+	} else {
+		response.BinaryBody = body
+	}
+
+	r.mu.Lock()
+	r.cassette.Interactions = append(r.cassette.Interactions, Interaction{
+		Request:  recorded,
+		Response: response,
+	})
+	r.mu.Unlock()
+
+	return response.toHTTP(req), nil
+}
+
+func (r *Recorder) replay(req *http.Request, recorded Request) (*http.Response, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, interaction := range r.cassette.Interactions {
+		if r.used[i] || interaction.Request != recorded {
+			continue
+		}
+		r.used[i] = true
+		return interaction.Response.toHTTP(req), nil
+	}
+
+	return nil, fmt.Errorf("vcr: no recorded interaction for %s %s in %s", recorded.Method, recorded.URL, r.path)
+}
+
+// Stop writes the cassette in ModeRecord. In ModeReplay it reports recorded
+// interactions that were never requested.
+func (r *Recorder) Stop() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.mode == ModeReplay {
+		var unused []string
+		for i, interaction := range r.cassette.Interactions {
+			if !r.used[i] {
+				unused = append(unused, interaction.Request.Method+" "+interaction.Request.URL)
+			}
+		}
+		if len(unused) > 0 {
+			return fmt.Errorf("vcr: %d recorded interactions were not replayed: %s", len(unused), strings.Join(unused, ", "))
+		}
+		return nil
+	}
+
+	data, err := json.MarshalIndent(r.cassette, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal cassette: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(r.path), 0o755); err != nil {
+		return fmt.Errorf("failed to create cassette directory: %w", err)
+	}
+
+	if err := os.WriteFile(r.path, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write cassette: %w", err)
+	}
+
+	return nil
+}
+
+func (resp Response) toHTTP(req *http.Request) *http.Response {
+	body := resp.BinaryBody
+	if body == nil {
+		body = []byte(resp.Body)
+	}
+
+	header := resp.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	// Bodies are recorded after the transport has decompressed them.
+	header.Del("Content-Encoding")
+	header.Del("Content-Length")
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", resp.StatusCode, http.StatusText(resp.StatusCode)),
+		StatusCode:    resp.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+// newRequest reads the body of req, restoring it for the real transport, and
+// returns the request as it is matched against the cassette. Multipart bodies
+// are reduced to one name=value line per field, since the boundary is random.
+func newRequest(req *http.Request) (Request, error) {
+	recorded := Request{
+		Method: req.Method,
+		URL:    req.URL.String(),
+	}
+
+	if req.Body == nil || req.Body == http.NoBody {
+		return recorded, nil
+	}
+
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return Request{}, fmt.Errorf("failed to read request body: %w", err)
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+
+	mediaType, params, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" {
+		recorded.Body = string(body)
+		return recorded, nil
+	}
+
+	var fields []string
+	reader := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return Request{}, fmt.Errorf("failed to read multipart body: %w", err)
+		}
+
+		value, err := io.ReadAll(part)
+		if err != nil {
+			return Request{}, fmt.Errorf("failed to read multipart body: %w", err)
+		}
+		fields = append(fields, part.FormName()+"="+string(value))
+	}
+	recorded.Body = strings.Join(fields, "\n")
+
+	return recorded, nil
+}
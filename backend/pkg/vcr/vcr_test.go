@@ -0,0 +1,115 @@
+package vcr
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Errorf("Failed to parse form: %v", err)
+		}
+		if r.Header.Get("Cookie") == "" {
+			t.Errorf("Request is missing its cookie")
+		}
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "secret"})
+		if r.FormValue("page") == "2" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		fmt.Fprintf(w, "page %s, call %d", r.FormValue("page"), calls)
+	}))
+	defer server.Close()
+
+	cassette := filepath.Join(t.TempDir(), "cassette.json")
+
+	recorder, err := New(cassette, ModeRecord, nil)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	recorded := []string{
+		doRequest(t, recorder.Client(), server.URL, "1"),
+		doRequest(t, recorder.Client(), server.URL, "1"),
+		doRequest(t, recorder.Client(), server.URL, "2"),
+	}
+	if err := recorder.Stop(); err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+
+	replayer, err := New(cassette, ModeReplay, nil)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	for i, page := range []string{"1", "1", "2"} {
+		if got := doRequest(t, replayer.Client(), server.URL, page); got != recorded[i] {
+			t.Errorf("replayed response %d = %q, want %q", i, got, recorded[i])
+		}
+	}
+	if err := replayer.Stop(); err != nil {
+		t.Errorf("Stop() error = %v", err)
+	}
+
+	if calls != 3 {
+		t.Errorf("server got %d calls, want 3", calls)
+	}
+
+	if _, err := replayer.Client().Get(server.URL); err == nil {
+		t.Errorf("expected error for request that was not recorded")
+	}
+
+	for _, interaction := range replayer.cassette.Interactions {
+		if interaction.Response.Header.Get("Set-Cookie") != "" {
+			t.Errorf("cassette contains Set-Cookie header")
+		}
+	}
+}
+
+func TestStopReportsUnusedInteractions(t *testing.T) {
+	recorder := &Recorder{
+		mode: ModeReplay,
+		cassette: Cassette{Interactions: []Interaction{
+			{Request: Request{Method: "GET", URL: "https://example.com"}},
+		}},
+		used: []bool{false},
+	}
+
+	if err := recorder.Stop(); err == nil {
+		t.Errorf("Stop() expected error for unused interaction")
+	}
+}
+
+func doRequest(t *testing.T, client *http.Client, url string, page string) string {
+	t.Helper()
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	writer.WriteField("page", page)
+	writer.Close()
+
+	req, err := http.NewRequest("POST", url, body)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("Cookie", "NetflixId=secret")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	return fmt.Sprintf("%d %s", resp.StatusCode, respBody)
+}